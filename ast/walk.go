@@ -0,0 +1,93 @@
+package ast
+
+// Inspect traverses the tree of node in depth-first order. It calls f for
+// each node and visits the children of the node only if f returns true.
+// The tree must be free of parser errors, which leave nodes missing.
+func Inspect(node Node, f func(Node) bool) {
+	if !f(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		for _, stmt := range node.Statements {
+			Inspect(stmt, f)
+		}
+	case *BlockStatement:
+		for _, stmt := range node.Statements {
+			Inspect(stmt, f)
+		}
+	case *LetStatement:
+		Inspect(node.Name, f)
+		Inspect(node.Value, f)
+	case *ReturnStatement:
+		Inspect(node.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(node.Expression, f)
+	case *ThrowStatement:
+		Inspect(node.Value, f)
+	case *ExportStatement:
+		Inspect(node.Statement, f)
+	case *ImportStatement:
+		Inspect(node.Path, f)
+		Inspect(node.Alias, f)
+	case *WhileStatement:
+		Inspect(node.Condition, f)
+		Inspect(node.Body, f)
+	case *ForStatement:
+		Inspect(node.Variable, f)
+		Inspect(node.Iterable, f)
+		Inspect(node.Body, f)
+	case *TryStatement:
+		Inspect(node.Block, f)
+		if node.CatchParameter != nil {
+			Inspect(node.CatchParameter, f)
+		}
+		if node.Catch != nil {
+			Inspect(node.Catch, f)
+		}
+		if node.Finally != nil {
+			Inspect(node.Finally, f)
+		}
+
+	case *PrefixExpression:
+		Inspect(node.Right, f)
+	case *InfixExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Right, f)
+	case *AssignExpression:
+		Inspect(node.Target, f)
+		Inspect(node.Value, f)
+	case *IfExpression:
+		Inspect(node.Condition, f)
+		Inspect(node.Consequence, f)
+		if node.Alternative != nil {
+			Inspect(node.Alternative, f)
+		}
+	case *FunctionLiteral:
+		for _, param := range node.Parameters {
+			Inspect(param, f)
+		}
+		Inspect(node.Body, f)
+	case *CallExpression:
+		Inspect(node.Function, f)
+		for _, arg := range node.Arguments {
+			Inspect(arg, f)
+		}
+	case *ArrayLiteral:
+		for _, element := range node.Elements {
+			Inspect(element, f)
+		}
+	case *IndexExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Index, f)
+	case *MemberExpression:
+		Inspect(node.Object, f)
+		Inspect(node.Property, f)
+	case *HashLiteral:
+		for _, key := range node.Keys {
+			Inspect(key, f)
+			Inspect(node.Pairs[key], f)
+		}
+	}
+}
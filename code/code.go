@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 type Instructions []byte
@@ -53,6 +54,22 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+
+	OpIter
+	OpIterNext
+
+	OpSetIndex
+	OpDup
+	OpSetFree
+	OpCaptureLocal
+	OpCaptureFree
+
+	OpSetupTry
+	OpPopTry
+	OpThrow
+
+	OpImport
+	OpTailCall
 )
 
 type Definition struct {
@@ -94,6 +111,18 @@ var definitions = map[OpCode]*Definition{
 	OpClosure:        {"OpClosure", []int{2, 1}},
 	OpGetFree:        {"OpGetFree", []int{1}},
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpIter:           {"OpIter", []int{}},
+	OpIterNext:       {"OpIterNext", []int{}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
+	OpDup:            {"OpDup", []int{1}},
+	OpSetFree:        {"OpSetFree", []int{1}},
+	OpCaptureLocal:   {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:    {"OpCaptureFree", []int{1}},
+	OpSetupTry:       {"OpSetupTry", []int{2}},
+	OpPopTry:         {"OpPopTry", []int{}},
+	OpThrow:          {"OpThrow", []int{}},
+	OpImport:         {"OpImport", []int{2, 2}},
+	OpTailCall:       {"OpTailCall", []int{1}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -196,3 +225,22 @@ func ReadUint16(instr Instructions) uint16 {
 func ReadUint8(instr Instructions) uint8 {
 	return uint8(instr[0])
 }
+
+// LineEntry marks the source line of all instructions starting at Offset up
+// to the Offset of the next entry.
+type LineEntry struct {
+	Offset int
+	Line   int
+}
+
+type LineTable []LineEntry
+
+func (lt LineTable) Lookup(offset int) (int, bool) {
+	i := sort.Search(len(lt), func(i int) bool {
+		return lt[i].Offset > offset
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return lt[i-1].Line, true
+}
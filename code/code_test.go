@@ -12,6 +12,7 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpImport, []int{1, 65534}, []byte{byte(OpImport), 0, 1, 255, 254}},
 	}
 
 	for _, tt := range tests {
@@ -89,3 +90,37 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestLineTableLookup(t *testing.T) {
+	table := LineTable{
+		{Offset: 0, Line: 1},
+		{Offset: 4, Line: 3},
+		{Offset: 9, Line: 2},
+	}
+
+	tests := []struct {
+		offset   int
+		expected int
+		ok       bool
+	}{
+		{0, 1, true},
+		{3, 1, true},
+		{4, 3, true},
+		{8, 3, true},
+		{9, 2, true},
+		{100, 2, true},
+		{-1, 0, false},
+	}
+
+	for _, tt := range tests {
+		line, ok := table.Lookup(tt.offset)
+		if ok != tt.ok || line != tt.expected {
+			t.Errorf("wrong line for offset %d. want=%d (%t), got=%d (%t)",
+				tt.offset, tt.expected, tt.ok, line, ok)
+		}
+	}
+
+	if _, ok := (LineTable{}).Lookup(0); ok {
+		t.Errorf("empty table should not resolve any offset")
+	}
+}
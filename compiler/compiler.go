@@ -4,10 +4,38 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/code"
+	"monkey/module"
 	"monkey/object"
-	"sort"
+	"monkey/token"
 )
 
+// compoundOperators maps compound assignment operators to the opcode
+// combining the current and the assigned value.
+var compoundOperators = map[string]code.OpCode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+// maxLocals is the number of locals and of free variables a function can
+// have, their instructions address them with one byte operands.
+const maxLocals = 256
+
+// checkScopeSize fails if a function has more locals or free variables than
+// its instructions can address.
+func checkScopeSize(name string, numLocals, numFree int) error {
+	if numLocals > maxLocals {
+		return fmt.Errorf("%s has too many local variables: %d, at most %d are allowed",
+			name, numLocals, maxLocals)
+	}
+	if numFree > maxLocals {
+		return fmt.Errorf("%s captures too many variables: %d, at most %d are allowed",
+			name, numFree, maxLocals)
+	}
+	return nil
+}
+
 type EmittedInstruction struct {
 	OpCode   code.OpCode
 	Position int
@@ -17,6 +45,25 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	lines               code.LineTable
+	loops               []*loopScope
+	tries               []tryScope
+	hiddenCount         int
+}
+
+// tryScope is a region with an installed exception handler. Leaving it
+// early through return, break or continue has to remove the handler and
+// run the finally block, if any.
+type tryScope struct {
+	finally   *ast.BlockStatement
+	loopDepth int
+}
+
+// loopScope collects the jumps of break statements, which can only be
+// patched once the end of the loop is known.
+type loopScope struct {
+	start      int
+	breakJumps []int
 }
 
 type Compiler struct {
@@ -24,6 +71,30 @@ type Compiler struct {
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+
+	// position of the node currently being compiled
+	position token.Position
+	filename string
+
+	modules *moduleLoader
+
+	optimization int
+	// indexes of the constants shared when optimizing
+	constantIndexes map[constantKey]int
+}
+
+// moduleLoader keeps track of the modules imported by a program. Every module
+// is compiled once into a function returning its exports, the hidden global
+// cache holds the exports once the function ran.
+type moduleLoader struct {
+	root       *SymbolTable
+	searchPath []string
+	compiled   map[string]int
+	loading    module.Loading
+}
+
+func newModuleLoader(root *SymbolTable) *moduleLoader {
+	return &moduleLoader{root: root, compiled: map[string]int{}}
 }
 
 func New() *Compiler {
@@ -43,6 +114,7 @@ func New() *Compiler {
 		},
 		scopeIndex:  0,
 		symbolTable: symbolTable,
+		modules:     newModuleLoader(symbolTable),
 	}
 }
 
@@ -58,17 +130,29 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 		},
 		scopeIndex:  0,
 		symbolTable: s,
+		modules:     newModuleLoader(s),
 	}
 }
 
+// SetSearchPath sets the directories searched for imported modules which are
+// not found next to the importing file.
+func (c *Compiler) SetSearchPath(dirs []string) {
+	c.modules.searchPath = dirs
+}
+
 func (c *Compiler) Compile(node ast.Node) error {
+	if pos := node.Pos(); pos.IsValid() {
+		previous := c.position
+		c.position = pos
+		defer func() { c.position = previous }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		c.filename = node.Pos().Filename
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
 		}
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
@@ -77,6 +161,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.InfixExpression:
+		if value, ok := c.constantValue(node); ok {
+			c.emitConstant(value)
+			return nil
+		}
+
 		err := c.Compile(node.Left)
 		if err != nil {
 			return err
@@ -112,6 +201,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.PrefixExpression:
+		if value, ok := c.constantValue(node); ok {
+			c.emitConstant(value)
+			return nil
+		}
+
 		err := c.Compile(node.Right)
 		if err != nil {
 			return err
@@ -129,6 +223,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
 
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -150,17 +248,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpArray, len(node.Elements))
 
 	case *ast.HashLiteral:
-		// this is only relevant to make tests not fail
-		// sorting the keys because iterating a map in go doesn't guarantee the expected order
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
+		// pairs are compiled in source order, which is the order of the hash
+		for _, k := range node.Keys {
 			err := c.Compile(k)
 			if err != nil {
 				return err
@@ -186,6 +275,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpIndex)
 
 	case *ast.IfExpression:
+		if condition, ok := c.constantValue(node.Condition); ok {
+			return c.compileConstantIf(node, condition)
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -199,9 +292,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastInstruction()
-		}
+		c.endBranch()
 
 		jumpPos := c.emit(code.OpJump, 0x1deadb0b)
 
@@ -216,20 +307,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastInstruction()
-			}
+			c.endBranch()
 		}
 
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
 		}
 
 	case *ast.LetStatement:
@@ -239,16 +326,98 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		c.storeSymbol(symbol)
+
+	case *ast.AssignExpression:
+		err := c.compileAssignment(node)
+		if err != nil {
+			return err
+		}
+
+	case *ast.WhileStatement:
+		if condition, ok := c.constantValue(node.Condition); ok {
+			return c.compileConstantWhile(node, condition)
+		}
+
+		start := len(c.currentInstructions())
+
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		// address will be patched later
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 0x1deadb0b)
+
+		err = c.compileLoopBody(start, node.Body)
+		if err != nil {
+			return err
+		}
+
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	case *ast.ForStatement:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIter)
+
+		// the iterator lives in a hidden variable which can't clash with
+		// identifiers of the program, one per nesting level
+		depth := len(c.scopes[c.scopeIndex].loops)
+		iterator := c.symbolTable.Define(fmt.Sprintf("$iter%d", depth))
+		c.storeSymbol(iterator)
+
+		start := len(c.currentInstructions())
+		c.loadSymbol(iterator)
+		c.emit(code.OpIterNext)
+
+		// address will be patched later
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 0x1deadb0b)
+
+		variable := c.symbolTable.Define(node.Variable.Value)
+		c.storeSymbol(variable)
+
+		err = c.compileLoopBody(start, node.Body)
+		if err != nil {
+			return err
 		}
 
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: break outside of loop", node.Pos())
+		}
+
+		err := c.unwindTries(len(c.scopes[c.scopeIndex].loops))
+		if err != nil {
+			return err
+		}
+
+		// address will be patched later
+		jumpPos := c.emit(code.OpJump, 0x1deadb0b)
+		loop.breakJumps = append(loop.breakJumps, jumpPos)
+
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: continue outside of loop", node.Pos())
+		}
+
+		err := c.unwindTries(len(c.scopes[c.scopeIndex].loops))
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpJump, loop.start)
+
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return fmt.Errorf("%s: undefined variable %s", node.Pos(), node.Value)
 		}
 		c.loadSymbol(symbol)
 
@@ -269,6 +438,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		if c.lastInstructionIs(code.OpPop) {
+			if previous := c.scopes[c.scopeIndex].previousInstruction; previous.OpCode == code.OpCall {
+				c.markTailCall(previous.Position)
+			}
 			c.replaceLastPopWithReturn()
 		}
 		if !c.lastInstructionIs(code.OpReturnValue) {
@@ -277,16 +449,30 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		localNames := c.symbolTable.definitionNames
+		lines := c.scopes[c.scopeIndex].lines
 		instructions := c.leaveScope()
 
-		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+		err = checkScopeSize("function", numLocals, len(freeSymbols))
+		if err != nil {
+			return fmt.Errorf("%s: %s", node.Pos(), err)
+		}
+
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			c.captureSymbol(s)
+			freeNames[i] = s.Name
 		}
 
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			Filename:      node.Pos().Filename,
+			Lines:         lines,
+			LocalNames:    localNames,
+			FreeNames:     freeNames,
 		}
 
 		fnIndex := c.addConstant(compiledFn)
@@ -295,7 +481,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.CallExpression:
 		err := c.Compile(node.Function)
 		if err != nil {
-			return nil
+			return err
 		}
 
 		for _, a := range node.Arguments {
@@ -313,26 +499,475 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		// the handler of an enclosing try statement has to stay in place
+		// while the callee runs
+		tries := c.scopes[c.scopeIndex].tries
+		if c.scopeIndex > 0 && len(tries) == 0 && c.lastInstructionIs(code.OpCall) {
+			c.markTailCall(c.scopes[c.scopeIndex].lastInstruction.Position)
+		}
+
+		err = c.unwindTries(0)
+		if err != nil {
+			return err
+		}
+
 		c.emit(code.OpReturnValue)
+
+	case *ast.TryStatement:
+		err := c.compileTry(node)
+		if err != nil {
+			return err
+		}
+
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpThrow)
+
+	case *ast.ImportStatement:
+		err := c.compileImport(node)
+		if err != nil {
+			return err
+		}
+
+	case *ast.ExportStatement:
+		err := c.Compile(node.Statement)
+		if err != nil {
+			return err
+		}
+
+	case *ast.MemberExpression:
+		err := c.Compile(node.Object)
+		if err != nil {
+			return err
+		}
+
+		property := &object.String{Value: node.Property.Value}
+		c.emit(code.OpConstant, c.addConstant(property))
+		c.emit(code.OpIndex)
+	}
+	return nil
+}
+
+// compileImport binds the exports of a module to the alias of node. The
+// module runs the first time an import of it is executed, later imports
+// reuse the exports cached in a hidden global.
+func (c *Compiler) compileImport(node *ast.ImportStatement) error {
+	path, err := module.Resolve(node.Path.Value, c.filename, c.modules.searchPath)
+	if err != nil {
+		return fmt.Errorf("%s: %s", node.Pos(), err)
+	}
+
+	fnIndex, ok := c.modules.compiled[path]
+	if !ok {
+		fnIndex, err = c.compileModule(path)
+		if err != nil {
+			return fmt.Errorf("%s: %s", node.Pos(), err)
+		}
+		c.modules.compiled[path] = fnIndex
+	}
+
+	cache := c.modules.root.Define("$module:" + path)
+	importPos := c.emit(code.OpImport, cache.Index, 0x1deadb0b)
+	c.emit(code.OpClosure, fnIndex, 0)
+	c.emit(code.OpCall, 0)
+	c.emit(code.OpDup, 1)
+	c.emit(code.OpSetGlobal, cache.Index)
+	afterImport := len(c.currentInstructions())
+	c.replaceInstruction(importPos, code.Make(code.OpImport, cache.Index, afterImport))
+
+	symbol := c.symbolTable.Define(node.Alias.Value)
+	c.storeSymbol(symbol)
+	return nil
+}
+
+// compileModule compiles the module at path into a function returning a hash
+// of its exports and returns the constant index of the function. Modules
+// have a namespace of their own, they only share the builtins with the
+// importing program.
+func (c *Compiler) compileModule(path string) (int, error) {
+	err := c.modules.loading.Enter(path)
+	if err != nil {
+		return 0, err
+	}
+	defer c.modules.loading.Leave()
+
+	program, err := module.Parse(path)
+	if err != nil {
+		return 0, err
+	}
+
+	symbolTable, filename := c.symbolTable, c.filename
+	c.symbolTable, c.filename = c.modules.root.builtinTable(), path
+	defer func() { c.symbolTable, c.filename = symbolTable, filename }()
+
+	c.enterScope()
+	err = c.compileStatements(program.Statements)
+	if err != nil {
+		c.leaveScope()
+		return 0, err
+	}
+
+	exports := module.Exports(program)
+	for _, name := range exports {
+		symbol, _ := c.symbolTable.Resolve(name)
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: name}))
+		c.loadSymbol(symbol)
+	}
+	c.emit(code.OpHash, len(exports)*2)
+	c.emit(code.OpReturnValue)
+
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.definitionNames
+	lines := c.scopes[c.scopeIndex].lines
+	instructions := c.leaveScope()
+
+	// the top level bindings of modules are locals of this function
+	err = checkScopeSize("module", numLocals, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions: instructions,
+		NumLocals:    numLocals,
+		Name:         "<module>",
+		Filename:     path,
+		Lines:        lines,
+		LocalNames:   localNames,
+	}
+	return c.addConstant(compiledFn), nil
+}
+
+func (c *Compiler) compileAssignment(node *ast.AssignExpression) error {
+	op, compound := compoundOperators[node.Operator]
+	if !compound && node.Operator != "=" {
+		return fmt.Errorf("%s: unknown assignment operator %s", node.Pos(), node.Operator)
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return fmt.Errorf("%s: undefined variable %s", target.Pos(), target.Value)
+		}
+
+		switch symbol.Scope {
+		case GlobalScope, LocalScope, FreeScope:
+		default:
+			return fmt.Errorf("%s: cannot assign to %s", target.Pos(), target.Value)
+		}
+
+		if compound {
+			c.loadSymbol(symbol)
+		}
+
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(op)
+		}
+
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+
+	case *ast.IndexExpression:
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(target.Index)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			// keep the container and the index for OpSetIndex
+			c.emit(code.OpDup, 2)
+			c.emit(code.OpIndex)
+		}
+
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(op)
+		}
+
+		c.emit(code.OpSetIndex)
+
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+
+	return nil
+}
+
+// compileTry lowers a try statement. OpSetupTry installs a handler which
+// continues at the catch block with the exception on the stack. The finally
+// block is inlined on every way out of the statement, if it is entered with
+// an uncaught exception, the exception is thrown again afterwards.
+func (c *Compiler) compileTry(node *ast.TryStatement) error {
+	endJumps := []int{}
+
+	// address will be patched later
+	setupPos := c.emit(code.OpSetupTry, 0x1deadb0b)
+
+	err := c.compileProtected(node.Block, node.Finally)
+	if err != nil {
+		return err
+	}
+	endJumps = append(endJumps, c.emit(code.OpJump, 0x1deadb0b))
+
+	c.changeOperand(setupPos, len(c.currentInstructions()))
+
+	if node.Catch != nil {
+		parameter := c.symbolTable.Define(node.CatchParameter.Value)
+
+		c.storeSymbol(parameter)
+
+		if node.Finally == nil {
+			err := c.Compile(node.Catch)
+			if err != nil {
+				return err
+			}
+			endJumps = append(endJumps, c.emit(code.OpJump, 0x1deadb0b))
+		} else {
+			// an exception thrown by the catch block still runs finally
+			setupPos = c.emit(code.OpSetupTry, 0x1deadb0b)
+
+			err := c.compileProtected(node.Catch, node.Finally)
+			if err != nil {
+				return err
+			}
+			endJumps = append(endJumps, c.emit(code.OpJump, 0x1deadb0b))
+
+			c.changeOperand(setupPos, len(c.currentInstructions()))
+		}
+	}
+
+	if node.Finally != nil {
+		scope := &c.scopes[c.scopeIndex]
+		exception := c.symbolTable.Define(fmt.Sprintf("$exception%d", scope.hiddenCount))
+		scope.hiddenCount++
+
+		c.storeSymbol(exception)
+
+		err := c.Compile(node.Finally)
+		if err != nil {
+			return err
+		}
+
+		c.loadSymbol(exception)
+		c.emit(code.OpThrow)
+	}
+
+	end := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, end)
+	}
+
+	return nil
+}
+
+// compileProtected compiles block while the handler installed right before
+// is active, then removes the handler and runs finally.
+func (c *Compiler) compileProtected(block, finally *ast.BlockStatement) error {
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = append(scope.tries, tryScope{
+		finally:   finally,
+		loopDepth: len(scope.loops),
+	})
+
+	err := c.Compile(block)
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpPopTry)
+
+	if finally != nil {
+		return c.Compile(finally)
+	}
+	return nil
+}
+
+// unwindTries removes the handlers of all try statements entered at the
+// given loop depth or deeper and runs their finally blocks, innermost
+// first. It is used before jumping out of them.
+func (c *Compiler) unwindTries(loopDepth int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+
+	for i := len(tries) - 1; i >= 0 && tries[i].loopDepth >= loopDepth; i-- {
+		c.emit(code.OpPopTry)
+
+		if tries[i].finally != nil {
+			// a jump out of the finally block only unwinds the outer ones
+			c.scopes[c.scopeIndex].tries = tries[:i]
+
+			err := c.Compile(tries[i].finally)
+			if err != nil {
+				return err
+			}
+		}
 	}
+
 	return nil
 }
 
+// compileStatements compiles the statements of a block. When optimizing,
+// the statements after a return, throw, break or continue are left out.
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	for i, s := range statements {
+		err := c.Compile(s)
+		if err != nil {
+			return err
+		}
+		if c.optimizing() && endsBlock(s) {
+			for _, dropped := range statements[i+1:] {
+				c.defineDropped(dropped)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// compileConstantIf compiles only the branch taken with the constant
+// condition of node.
+func (c *Compiler) compileConstantIf(node *ast.IfExpression, condition object.Object) error {
+	branch, dropped := node.Alternative, node.Consequence
+	if isTruthy(condition) {
+		branch, dropped = node.Consequence, node.Alternative
+	}
+	if dropped != nil {
+		c.defineDropped(dropped)
+	}
+
+	if branch == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	start := len(c.currentInstructions())
+	err := c.Compile(branch)
+	if err != nil {
+		return err
+	}
+
+	// the last instruction may belong to the code before an empty branch
+	if len(c.currentInstructions()) == start {
+		c.emit(code.OpNull)
+	} else {
+		c.endBranch()
+	}
+	return nil
+}
+
+// compileConstantWhile leaves out a loop which never runs and the condition
+// of a loop which only ends through break.
+func (c *Compiler) compileConstantWhile(node *ast.WhileStatement, condition object.Object) error {
+	if !isTruthy(condition) {
+		c.defineDropped(node.Body)
+		return nil
+	}
+	return c.compileLoopBody(len(c.currentInstructions()), node.Body)
+}
+
+// compileLoopBody compiles the body of a loop starting at start, emits the
+// jump back to start and patches the break statements of the body to jump
+// past the loop.
+func (c *Compiler) compileLoopBody(start int, body *ast.BlockStatement) error {
+	scope := &c.scopes[c.scopeIndex]
+	loop := &loopScope{start: start}
+	scope.loops = append(scope.loops, loop)
+
+	err := c.Compile(body)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpJump, start)
+
+	end := len(c.currentInstructions())
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, end)
+	}
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	return nil
+}
+
+func (c *Compiler) currentLoop() *loopScope {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// endBranch makes sure a branch of a conditional leaves exactly one value on
+// the stack, even if its last statement doesn't produce one.
+func (c *Compiler) endBranch() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastInstruction()
+	} else {
+		c.emit(code.OpNull)
+	}
+}
+
 func (c *Compiler) ByteCode() *ByteCode {
 	return &ByteCode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Lines:        c.scopes[c.scopeIndex].lines,
+		Filename:     c.filename,
+		GlobalNames:  c.symbolTable.definitionNames,
 	}
 }
 
 type ByteCode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+
+	// debug information of the main program
+	Lines       code.LineTable
+	Filename    string
+	GlobalNames []string
 }
 
 func (c *Compiler) addConstant(obj object.Object) int {
+	key, shared := c.sharedConstant(obj)
+	if index, ok := c.constantIndexes[key]; shared && ok {
+		return index
+	}
+
 	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+	index := len(c.constants) - 1
+
+	if shared {
+		if c.constantIndexes == nil {
+			c.constantIndexes = map[constantKey]int{}
+		}
+		c.constantIndexes[key] = index
+	}
+	return index
 }
 
 func (c *Compiler) emit(op code.OpCode, operands ...int) int {
@@ -360,6 +995,12 @@ func (c *Compiler) removeLastInstruction() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	lines := c.scopes[c.scopeIndex].lines
+	for len(lines) > 0 && lines[len(lines)-1].Offset >= last.Position {
+		lines = lines[:len(lines)-1]
+	}
+	c.scopes[c.scopeIndex].lines = lines
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -375,6 +1016,20 @@ func (c *Compiler) replaceLastPopWithReturn() {
 	c.scopes[c.scopeIndex].lastInstruction.OpCode = code.OpReturnValue
 }
 
+// markTailCall turns the OpCall at pos, whose result the function returns,
+// into an OpTailCall. The callee then replaces the frame of the function.
+func (c *Compiler) markTailCall(pos int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions[pos] = byte(code.OpTailCall)
+
+	if scope.lastInstruction.Position == pos {
+		scope.lastInstruction.OpCode = code.OpTailCall
+	}
+	if scope.previousInstruction.Position == pos {
+		scope.previousInstruction.OpCode = code.OpTailCall
+	}
+}
+
 func (c *Compiler) changeOperand(opPos int, operand int) {
 	op := code.OpCode(c.scopes[c.scopeIndex].instructions[opPos])
 	newInstruction := code.Make(op, operand)
@@ -385,9 +1040,24 @@ func (c *Compiler) addInstruction(instr []byte) int {
 	posNewInstr := len(c.currentInstructions())
 	updatedInstr := append(c.currentInstructions(), instr...)
 	c.scopes[c.scopeIndex].instructions = updatedInstr
+	c.addLine(posNewInstr)
 	return posNewInstr
 }
 
+func (c *Compiler) addLine(pos int) {
+	if !c.position.IsValid() {
+		return
+	}
+
+	lines := c.scopes[c.scopeIndex].lines
+	if len(lines) > 0 && lines[len(lines)-1].Line == c.position.Line {
+		return
+	}
+
+	entry := code.LineEntry{Offset: pos, Line: c.position.Line}
+	c.scopes[c.scopeIndex].lines = append(lines, entry)
+}
+
 func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
@@ -420,6 +1090,30 @@ func (c *Compiler) lastInstructionIs(op code.OpCode) bool {
 	return c.scopes[c.scopeIndex].lastInstruction.OpCode == op
 }
 
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// captureSymbol pushes the cell of a variable captured by a closure, so
+// the closure shares the variable instead of copying its value.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -82,6 +82,32 @@ func TestIntegerArithmetic(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1.5 + 2",
+			expectedConstants: []any{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-0.25",
+			expectedConstants: []any{0.25},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -219,6 +245,308 @@ func TestConditionals(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestConditionalsWithoutValue(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { let a = 1; }",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 14), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpSetGlobal, 0),      // 0007
+				code.Make(code.OpNull),              // 0010
+				code.Make(code.OpJump, 15),          // 0011
+				code.Make(code.OpNull),              // 0014
+				code.Make(code.OpPop),               // 0015
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { 1; }; 2;",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 11), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpPop),               // 0007
+				code.Make(code.OpJump, 0),           // 0008
+				code.Make(code.OpConstant, 1),       // 0011
+				code.Make(code.OpPop),               // 0014
+			},
+		},
+		{
+			input:             "while (true) { if (false) { break; }; continue; }",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 23), // 0001
+				code.Make(code.OpFalse),             // 0004
+				code.Make(code.OpJumpNotTruthy, 15), // 0005
+				code.Make(code.OpJump, 23),          // 0008
+				code.Make(code.OpNull),              // 0011
+				code.Make(code.OpJump, 16),          // 0012
+				code.Make(code.OpNull),              // 0015
+				code.Make(code.OpPop),               // 0016
+				code.Make(code.OpJump, 0),           // 0017
+				code.Make(code.OpJump, 0),           // 0020
+			},
+		},
+		{
+			input:             "for (x in [1]) { x; }",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),       // 0000
+				code.Make(code.OpArray, 1),          // 0003
+				code.Make(code.OpIter),              // 0006
+				code.Make(code.OpSetGlobal, 0),      // 0007
+				code.Make(code.OpGetGlobal, 0),      // 0010
+				code.Make(code.OpIterNext),          // 0013
+				code.Make(code.OpJumpNotTruthy, 27), // 0014
+				code.Make(code.OpSetGlobal, 1),      // 0017
+				code.Make(code.OpGetGlobal, 1),      // 0020
+				code.Make(code.OpPop),               // 0023
+				code.Make(code.OpJump, 10),          // 0024
+			},
+		},
+		{
+			input: "fn() { for (x in []) { x; } }",
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpArray, 0),          // 0000
+					code.Make(code.OpIter),              // 0003
+					code.Make(code.OpSetLocal, 0),       // 0004
+					code.Make(code.OpGetLocal, 0),       // 0006
+					code.Make(code.OpIterNext),          // 0008
+					code.Make(code.OpJumpNotTruthy, 20), // 0009
+					code.Make(code.OpSetLocal, 1),       // 0012
+					code.Make(code.OpGetLocal, 1),       // 0014
+					code.Make(code.OpPop),               // 0016
+					code.Make(code.OpJump, 6),           // 0017
+					code.Make(code.OpReturn),            // 0020
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoopControlOutsideOfLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside of loop"},
+		{"if (true) { continue; }", "1:13: continue outside of loop"},
+		{"while (true) { fn() { break; } }", "1:23: break outside of loop"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; x += 2;",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = [1]; a[0] *= 2;",
+			expectedConstants: []any{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDup, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			fn() {
+				let x = 1;
+				fn() { x = 2 }
+			}
+			`,
+			expectedConstants: []any{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1;", "1:1: undefined variable x"},
+		{"len = 1;", "1:1: cannot assign to len"},
+		{"len += 1;", "1:1: cannot assign to len"},
+		{"let f = fn() { f = 1 };", "1:16: cannot assign to f"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `throw "boom";`,
+			expectedConstants: []any{"boom"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpThrow),
+			},
+		},
+		{
+			input:             "try { 1 } catch (e) { e }",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpSetupTry, 11), // 0000
+				code.Make(code.OpConstant, 0),  // 0003
+				code.Make(code.OpPop),          // 0006
+				code.Make(code.OpPopTry),       // 0007
+				code.Make(code.OpJump, 21),     // 0008
+				code.Make(code.OpSetGlobal, 0), // 0011
+				code.Make(code.OpGetGlobal, 0), // 0014
+				code.Make(code.OpPop),          // 0017
+				code.Make(code.OpJump, 21),     // 0018
+			},
+		},
+		{
+			input:             "try { 1 } finally { 2 }",
+			expectedConstants: []any{1, 2, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpSetupTry, 15), // 0000
+				code.Make(code.OpConstant, 0),  // 0003
+				code.Make(code.OpPop),          // 0006
+				code.Make(code.OpPopTry),       // 0007
+				code.Make(code.OpConstant, 1),  // 0008
+				code.Make(code.OpPop),          // 0011
+				code.Make(code.OpJump, 26),     // 0012
+				code.Make(code.OpSetGlobal, 0), // 0015
+				code.Make(code.OpConstant, 2),  // 0018
+				code.Make(code.OpPop),          // 0021
+				code.Make(code.OpGetGlobal, 0), // 0022
+				code.Make(code.OpThrow),        // 0025
+			},
+		},
+		{
+			input: "fn() { try { return 1; } finally { 2 } }",
+			expectedConstants: []any{
+				1,
+				2,
+				2,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpSetupTry, 20), // 0000
+					code.Make(code.OpConstant, 0),  // 0003
+					code.Make(code.OpPopTry),       // 0006
+					code.Make(code.OpConstant, 1),  // 0007
+					code.Make(code.OpPop),          // 0010
+					code.Make(code.OpReturnValue),  // 0011
+					code.Make(code.OpPopTry),       // 0012
+					code.Make(code.OpConstant, 2),  // 0013
+					code.Make(code.OpPop),          // 0016
+					code.Make(code.OpJump, 29),     // 0017
+					code.Make(code.OpSetLocal, 0),  // 0020
+					code.Make(code.OpConstant, 3),  // 0022
+					code.Make(code.OpPop),          // 0025
+					code.Make(code.OpGetLocal, 0),  // 0026
+					code.Make(code.OpThrow),        // 0028
+					code.Make(code.OpReturn),       // 0029
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 4, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -402,6 +730,18 @@ func TestIndexExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `{"a": 1}.a`,
+			expectedConstants: []any{"a", 1, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -720,7 +1060,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -752,7 +1092,7 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -782,13 +1122,13 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -836,15 +1176,15 @@ func TestClosures(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -861,6 +1201,91 @@ func TestClosures(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(f) { return f(); }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(f) { if (f) { f() } else { f(1) } }`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpJumpNotTruthy, 12),
+					// 0005
+					code.Make(code.OpGetLocal, 0),
+					// 0007
+					code.Make(code.OpCall, 0),
+					// 0009
+					code.Make(code.OpJump, 19),
+					// 0012
+					code.Make(code.OpGetLocal, 0),
+					// 0014
+					code.Make(code.OpConstant, 0),
+					// 0017
+					code.Make(code.OpTailCall, 1),
+					// 0019
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// the handler of the try statement has to stay in place
+			input: `fn(f) { try { return f(); } finally {} }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpSetupTry, 13),
+					// 0003
+					code.Make(code.OpGetLocal, 0),
+					// 0005
+					code.Make(code.OpCall, 0),
+					// 0007
+					code.Make(code.OpPopTry),
+					// 0008
+					code.Make(code.OpReturnValue),
+					// 0009
+					code.Make(code.OpPopTry),
+					// 0010
+					code.Make(code.OpJump, 18),
+					// 0013
+					code.Make(code.OpSetLocal, 1),
+					// 0015
+					code.Make(code.OpGetLocal, 1),
+					// 0017
+					code.Make(code.OpThrow),
+					// 0018
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestRecursiveFunctions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -875,7 +1300,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 				1,
@@ -904,7 +1329,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 				1,
@@ -913,7 +1338,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -930,13 +1355,123 @@ func TestRecursiveFunctions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestOptimizations(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []any{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key" == "monkey"; !(1 < 2); -(2 - 3)`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// division by zero is left to the VM, which raises the error
+			input:             "len / 1; 1 / 0",
+			expectedConstants: []any{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = 1; a + a + 1; 1",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (1 > 2) { 10 } else { 20 }; if (true) { 30 }; if (false) { 40 }",
+			expectedConstants: []any{20, 30},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1; if (true) {}",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "while (false) { 1 }; while (true) { break; 2 }",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpJump, 6),
+				// 0003
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input: "fn() { return 1; 2 }",
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsAt(t, OptimizeBasic, tests)
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
+	runCompilerTestsAt(t, OptimizeNone, tests)
+}
+
+func runCompilerTestsAt(t *testing.T, level int, tests []compilerTestCase) {
+	t.Helper()
 
 	for _, tt := range tests {
 		program := parse(tt.input)
 
 		compiler := New()
+		compiler.SetOptimizationLevel(level)
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -1011,6 +1546,12 @@ func testConstants(
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s",
 					i, err)
 			}
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObject failed: %s",
+					i, err)
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -1050,6 +1591,21 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value: got=%g, want=%g",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 func testStringObject(expected string, actual object.Object) error {
 	result, ok := actual.(*object.String)
 	if !ok {
@@ -1064,3 +1620,66 @@ func testStringObject(expected string, actual object.Object) error {
 
 	return nil
 }
+
+func TestLineTables(t *testing.T) {
+	input := `let a = 1;
+
+let f = fn(x) {
+	let y = x * 2;
+	y + a
+};
+f(a);`
+
+	program := parseFile(input, "lines.monkey")
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.ByteCode()
+	if bytecode.Filename != "lines.monkey" {
+		t.Errorf("wrong filename. want=%q, got=%q", "lines.monkey", bytecode.Filename)
+	}
+
+	expectedMain := code.LineTable{
+		{Offset: 0, Line: 1},
+		{Offset: 6, Line: 3},
+		{Offset: 13, Line: 7},
+	}
+	testLineTable(t, expectedMain, bytecode.Lines)
+
+	fn, ok := bytecode.Constants[2].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 2 is not a function: %T", bytecode.Constants[2])
+	}
+	if fn.Name != "f" || fn.Filename != "lines.monkey" {
+		t.Errorf("wrong debug info. got name=%q, filename=%q", fn.Name, fn.Filename)
+	}
+
+	expectedFn := code.LineTable{
+		{Offset: 0, Line: 4},
+		{Offset: 8, Line: 5},
+	}
+	testLineTable(t, expectedFn, fn.Lines)
+}
+
+func parseFile(input, filename string) *ast.Program {
+	lexer := lexer.NewWithFilename(input, filename)
+	parser := parser.New(lexer)
+	return parser.ParseProgram()
+}
+
+func testLineTable(t *testing.T, expected, actual code.LineTable) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("wrong line table length. want=%+v, got=%+v", expected, actual)
+	}
+
+	for i, entry := range expected {
+		if actual[i] != entry {
+			t.Errorf("wrong line entry %d. want=%+v, got=%+v", i, entry, actual[i])
+		}
+	}
+}
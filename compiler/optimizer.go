@@ -0,0 +1,302 @@
+package compiler
+
+import (
+	"math"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+	"strconv"
+	"strings"
+)
+
+// Optimization levels of the compiler, see SetOptimizationLevel.
+const (
+	// OptimizeNone compiles programs as written.
+	OptimizeNone = iota
+	// OptimizeBasic folds constant expressions, leaves out unreachable code
+	// and stores identical constants once.
+	OptimizeBasic
+)
+
+// SetOptimizationLevel sets how much the compiler optimizes the programs
+// it compiles. The default is OptimizeNone.
+func (c *Compiler) SetOptimizationLevel(level int) {
+	c.optimization = level
+}
+
+func (c *Compiler) optimizing() bool {
+	return c.optimization >= OptimizeBasic
+}
+
+// constantValue returns the value of node if the compiler optimizes and the
+// value is known at compile time.
+func (c *Compiler) constantValue(node ast.Expression) (object.Object, bool) {
+	if !c.optimizing() {
+		return nil, false
+	}
+	return constantValue(node)
+}
+
+func (c *Compiler) emitConstant(value object.Object) {
+	switch value := value.(type) {
+	case *object.Boolean:
+		if value.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	default:
+		c.emit(code.OpConstant, c.addConstant(value))
+	}
+}
+
+// sharedConstant returns the key under which obj is stored once with the
+// constants equal to it. Only immutable values are shared.
+func (c *Compiler) sharedConstant(obj object.Object) (constantKey, bool) {
+	if !c.optimizing() {
+		return constantKey{}, false
+	}
+
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{obj.Type(), strconv.FormatInt(obj.Value, 10)}, true
+	case *object.Float:
+		// compare bits, so that 0.0 and -0.0 stay different constants
+		bits := math.Float64bits(obj.Value)
+		return constantKey{obj.Type(), strconv.FormatUint(bits, 16)}, true
+	case *object.String:
+		return constantKey{obj.Type(), obj.Value}, true
+	default:
+		return constantKey{}, false
+	}
+}
+
+type constantKey struct {
+	Type  object.ObjectType
+	Value string
+}
+
+// defineDropped defines the variables bound by node, code left out as
+// unreachable. Programs then compile the same with and without optimizing,
+// reading such a variable fails at runtime in both cases. The variables of
+// function literals belong to their own scope and are left alone.
+func (c *Compiler) defineDropped(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			c.defineDropped(s)
+		}
+	case *ast.LetStatement:
+		c.symbolTable.Define(node.Name.Value)
+		c.defineDropped(node.Value)
+	case *ast.ExpressionStatement:
+		c.defineDropped(node.Expression)
+	case *ast.ReturnStatement:
+		c.defineDropped(node.ReturnValue)
+	case *ast.ThrowStatement:
+		c.defineDropped(node.Value)
+	case *ast.ExportStatement:
+		c.defineDropped(node.Statement)
+	case *ast.ImportStatement:
+		c.symbolTable.Define(node.Alias.Value)
+	case *ast.WhileStatement:
+		c.defineDropped(node.Condition)
+		c.defineDropped(node.Body)
+	case *ast.ForStatement:
+		c.defineDropped(node.Iterable)
+		c.symbolTable.Define(node.Variable.Value)
+		c.defineDropped(node.Body)
+	case *ast.TryStatement:
+		c.defineDropped(node.Block)
+		if node.Catch != nil {
+			c.symbolTable.Define(node.CatchParameter.Value)
+			c.defineDropped(node.Catch)
+		}
+		if node.Finally != nil {
+			c.defineDropped(node.Finally)
+		}
+	case *ast.IfExpression:
+		c.defineDropped(node.Condition)
+		c.defineDropped(node.Consequence)
+		if node.Alternative != nil {
+			c.defineDropped(node.Alternative)
+		}
+	case *ast.PrefixExpression:
+		c.defineDropped(node.Right)
+	case *ast.InfixExpression:
+		c.defineDropped(node.Left)
+		c.defineDropped(node.Right)
+	case *ast.AssignExpression:
+		c.defineDropped(node.Target)
+		c.defineDropped(node.Value)
+	case *ast.CallExpression:
+		c.defineDropped(node.Function)
+		for _, a := range node.Arguments {
+			c.defineDropped(a)
+		}
+	case *ast.IndexExpression:
+		c.defineDropped(node.Left)
+		c.defineDropped(node.Index)
+	case *ast.MemberExpression:
+		c.defineDropped(node.Object)
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			c.defineDropped(e)
+		}
+	case *ast.HashLiteral:
+		for _, key := range node.Keys {
+			c.defineDropped(key)
+			c.defineDropped(node.Pairs[key])
+		}
+	}
+}
+
+// endsBlock reports whether the statements following stmt in a block are
+// unreachable.
+func endsBlock(stmt ast.Statement) bool {
+	switch stmt.(type) {
+	case *ast.ReturnStatement, *ast.ThrowStatement,
+		*ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	default:
+		return false
+	}
+}
+
+// constantValue computes the value of a constant expression following the
+// semantics of the VM. It gives up on operations which fail at runtime, like
+// division by zero, so that the VM still raises the error.
+func constantValue(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.Boolean:
+		return &object.Boolean{Value: node.Value}, true
+
+	case *ast.PrefixExpression:
+		right, ok := constantValue(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldPrefix(node.Operator, right)
+
+	case *ast.InfixExpression:
+		left, ok := constantValue(node.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := constantValue(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(node.Operator, left, right)
+
+	default:
+		return nil, false
+	}
+}
+
+func foldPrefix(operator string, right object.Object) (object.Object, bool) {
+	switch operator {
+	case "!":
+		return &object.Boolean{Value: !isTruthy(right)}, true
+	case "-":
+		if right, ok := right.(*object.Integer); ok {
+			return &object.Integer{Value: -right.Value}, true
+		}
+	}
+	return nil, false
+}
+
+func foldInfix(operator string, left, right object.Object) (object.Object, bool) {
+	switch left := left.(type) {
+	case *object.Integer:
+		if right, ok := right.(*object.Integer); ok {
+			return foldIntegerInfix(operator, left.Value, right.Value)
+		}
+	case *object.String:
+		if right, ok := right.(*object.String); ok {
+			return foldStringInfix(operator, left.Value, right.Value)
+		}
+	case *object.Boolean:
+		if right, ok := right.(*object.Boolean); ok {
+			switch operator {
+			case "==":
+				return &object.Boolean{Value: left.Value == right.Value}, true
+			case "!=":
+				return &object.Boolean{Value: left.Value != right.Value}, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func foldIntegerInfix(operator string, left, right int64) (object.Object, bool) {
+	switch operator {
+	case "+":
+		return &object.Integer{Value: left + right}, true
+	case "-":
+		return &object.Integer{Value: left - right}, true
+	case "*":
+		return &object.Integer{Value: left * right}, true
+	case "/":
+		if right == 0 {
+			return nil, false
+		}
+		return &object.Integer{Value: left / right}, true
+	}
+	return foldComparison(operator, compareInt(left, right))
+}
+
+func foldStringInfix(operator, left, right string) (object.Object, bool) {
+	if operator == "+" {
+		return &object.String{Value: left + right}, true
+	}
+	return foldComparison(operator, strings.Compare(left, right))
+}
+
+func foldComparison(operator string, cmp int) (object.Object, bool) {
+	var result bool
+	switch operator {
+	case "==":
+		result = cmp == 0
+	case "!=":
+		result = cmp != 0
+	case "<":
+		result = cmp < 0
+	case "<=":
+		result = cmp <= 0
+	case ">":
+		result = cmp > 0
+	case ">=":
+		result = cmp >= 0
+	default:
+		return nil, false
+	}
+	return &object.Boolean{Value: result}, true
+}
+
+func compareInt(left, right int64) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// isTruthy mirrors the truthiness of the VM for constant values.
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
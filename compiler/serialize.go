@@ -0,0 +1,358 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"monkey/code"
+	"monkey/object"
+)
+
+// Layout of a serialized ByteCode:
+//
+//	magic    [4]byte  "\x00MKC"
+//	version  uint16   big endian
+//	length   uint32   big endian, length of the payload
+//	checksum uint32   big endian, CRC-32 (IEEE) of the payload
+//	payload  [length]byte
+//
+// All integers inside the payload are varint encoded, strings and
+// instructions are prefixed with their length.
+//
+// Version 2 added the names of globals, locals and free variables,
+// version 3 added float constants, version 4 the opcodes of loops,
+// assignments, exceptions, modules and tail calls.
+const (
+	FormatVersion = 4
+	headerSize    = 14
+)
+
+var magic = []byte("\x00MKC")
+
+var (
+	ErrInvalidMagic       = errors.New("not a compiled monkey file")
+	ErrUnsupportedVersion = errors.New("unsupported bytecode format version")
+	ErrChecksumMismatch   = errors.New("bytecode checksum mismatch")
+)
+
+const (
+	constantInteger byte = iota + 1
+	constantString
+	constantCompiledFunction
+	constantFloat
+)
+
+func (b *ByteCode) MarshalBinary() ([]byte, error) {
+	e := &encoder{}
+
+	e.writeString(b.Filename)
+	e.writeInstructions(b.Instructions)
+	e.writeLines(b.Lines)
+	e.writeNames(b.GlobalNames)
+
+	e.writeUint(uint64(len(b.Constants)))
+	for i, constant := range b.Constants {
+		err := e.writeConstant(constant)
+		if err != nil {
+			return nil, fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+
+	payload := e.buf.Bytes()
+
+	out := make([]byte, headerSize, headerSize+len(payload))
+	copy(out, magic)
+	binary.BigEndian.PutUint16(out[4:], FormatVersion)
+	binary.BigEndian.PutUint32(out[6:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(out[10:], crc32.ChecksumIEEE(payload))
+
+	return append(out, payload...), nil
+}
+
+func (b *ByteCode) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize || !bytes.Equal(data[:4], magic) {
+		return ErrInvalidMagic
+	}
+
+	version := binary.BigEndian.Uint16(data[4:])
+	if version == 0 || version > FormatVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	length := binary.BigEndian.Uint32(data[6:])
+	checksum := binary.BigEndian.Uint32(data[10:])
+
+	payload := data[headerSize:]
+	if uint32(len(payload)) != length {
+		return fmt.Errorf("bytecode truncated: want %d bytes, got %d",
+			length, len(payload))
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return ErrChecksumMismatch
+	}
+
+	d := &decoder{data: payload, version: version}
+
+	filename := d.readString()
+	instructions := d.readInstructions()
+	lines := d.readLines()
+	globalNames := d.readNames()
+
+	count := d.readUint()
+	constants := []object.Object{}
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		constants = append(constants, d.readConstant())
+	}
+
+	if d.err != nil {
+		return d.err
+	}
+	if d.offset != len(d.data) {
+		return fmt.Errorf("%d unexpected trailing bytes", len(d.data)-d.offset)
+	}
+
+	b.Filename = filename
+	b.Instructions = instructions
+	b.Lines = lines
+	b.GlobalNames = globalNames
+	b.Constants = constants
+
+	return nil
+}
+
+func (b *ByteCode) WriteTo(w io.Writer) (int64, error) {
+	data, err := b.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+func ReadByteCode(r io.Reader) (*ByteCode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bytecode := &ByteCode{}
+	err = bytecode.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytecode, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) writeUint(v uint64) {
+	e.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (e *encoder) writeInt(v int64) {
+	e.buf.Write(binary.AppendVarint(nil, v))
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeUint(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) writeInstructions(instr code.Instructions) {
+	e.writeUint(uint64(len(instr)))
+	e.buf.Write(instr)
+}
+
+func (e *encoder) writeLines(lines code.LineTable) {
+	e.writeUint(uint64(len(lines)))
+	for _, entry := range lines {
+		e.writeUint(uint64(entry.Offset))
+		e.writeUint(uint64(entry.Line))
+	}
+}
+
+func (e *encoder) writeNames(names []string) {
+	e.writeUint(uint64(len(names)))
+	for _, name := range names {
+		e.writeString(name)
+	}
+}
+
+func (e *encoder) writeConstant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(constantInteger)
+		e.writeInt(obj.Value)
+	case *object.Float:
+		e.buf.WriteByte(constantFloat)
+		e.buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(obj.Value)))
+	case *object.String:
+		e.buf.WriteByte(constantString)
+		e.writeString(obj.Value)
+	case *object.CompiledFunction:
+		e.buf.WriteByte(constantCompiledFunction)
+		e.writeInstructions(obj.Instructions)
+		e.writeUint(uint64(obj.NumLocals))
+		e.writeUint(uint64(obj.NumParameters))
+		e.writeString(obj.Name)
+		e.writeString(obj.Filename)
+		e.writeLines(obj.Lines)
+		e.writeNames(obj.LocalNames)
+		e.writeNames(obj.FreeNames)
+	default:
+		return fmt.Errorf("cannot serialize constant of type %s", obj.Type())
+	}
+	return nil
+}
+
+type decoder struct {
+	data    []byte
+	offset  int
+	err     error
+	version uint16
+}
+
+func (d *decoder) fail(format string, a ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("malformed bytecode at offset %d: %s",
+			d.offset, fmt.Sprintf(format, a...))
+	}
+}
+
+func (d *decoder) readUint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.offset:])
+	if n <= 0 {
+		d.fail("invalid unsigned integer")
+		return 0
+	}
+	d.offset += n
+	return v
+}
+
+func (d *decoder) readInt() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.offset:])
+	if n <= 0 {
+		d.fail("invalid integer")
+		return 0
+	}
+	d.offset += n
+	return v
+}
+
+func (d *decoder) readFloat() float64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data)-d.offset < 8 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	bits := binary.BigEndian.Uint64(d.data[d.offset:])
+	d.offset += 8
+	return math.Float64frombits(bits)
+}
+
+func (d *decoder) readByte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.offset >= len(d.data) {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[d.offset]
+	d.offset++
+	return b
+}
+
+func (d *decoder) readBytes() []byte {
+	length := d.readUint()
+	if d.err != nil {
+		return nil
+	}
+	if length > uint64(len(d.data)-d.offset) {
+		d.fail("length %d exceeds remaining data", length)
+		return nil
+	}
+	b := d.data[d.offset : d.offset+int(length)]
+	d.offset += int(length)
+	return b
+}
+
+func (d *decoder) readString() string {
+	return string(d.readBytes())
+}
+
+func (d *decoder) readInstructions() code.Instructions {
+	b := d.readBytes()
+	instr := make(code.Instructions, len(b))
+	copy(instr, b)
+	return instr
+}
+
+func (d *decoder) readLines() code.LineTable {
+	count := d.readUint()
+	lines := code.LineTable{}
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		offset := d.readUint()
+		line := d.readUint()
+		lines = append(lines, code.LineEntry{Offset: int(offset), Line: int(line)})
+	}
+	return lines
+}
+
+func (d *decoder) readNames() []string {
+	if d.version < 2 {
+		return nil
+	}
+
+	count := d.readUint()
+	names := []string{}
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		names = append(names, d.readString())
+	}
+	return names
+}
+
+func (d *decoder) readConstant() object.Object {
+	tag := d.readByte()
+	if d.err != nil {
+		return nil
+	}
+
+	switch tag {
+	case constantInteger:
+		return &object.Integer{Value: d.readInt()}
+	case constantFloat:
+		return &object.Float{Value: d.readFloat()}
+	case constantString:
+		return &object.String{Value: d.readString()}
+	case constantCompiledFunction:
+		fn := &object.CompiledFunction{}
+		fn.Instructions = d.readInstructions()
+		fn.NumLocals = int(d.readUint())
+		fn.NumParameters = int(d.readUint())
+		fn.Name = d.readString()
+		fn.Filename = d.readString()
+		fn.Lines = d.readLines()
+		fn.LocalNames = d.readNames()
+		fn.FreeNames = d.readNames()
+		return fn
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
@@ -0,0 +1,149 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"monkey/object"
+	"testing"
+)
+
+func TestByteCodeRoundTrip(t *testing.T) {
+	input := `let greeting = "hello";
+let add = fn(a, b) {
+	let sum = a + b;
+	sum
+};
+puts(greeting, add(-12, 30), 0.1 * 3e8);`
+
+	program := parseFile(input, "roundtrip.monkey")
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	original := compiler.ByteCode()
+
+	var buf bytes.Buffer
+	_, err = original.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %s", err)
+	}
+
+	loaded, err := ReadByteCode(&buf)
+	if err != nil {
+		t.Fatalf("ReadByteCode failed: %s", err)
+	}
+
+	if loaded.Filename != original.Filename {
+		t.Errorf("wrong filename. want=%q, got=%q", original.Filename, loaded.Filename)
+	}
+	if !bytes.Equal(loaded.Instructions, original.Instructions) {
+		t.Errorf("wrong instructions.\nwant=%q\ngot=%q",
+			original.Instructions, loaded.Instructions)
+	}
+	testLineTable(t, original.Lines, loaded.Lines)
+	testNames(t, original.GlobalNames, loaded.GlobalNames)
+
+	if len(loaded.Constants) != len(original.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d",
+			len(original.Constants), len(loaded.Constants))
+	}
+
+	for i, want := range original.Constants {
+		got := loaded.Constants[i]
+		if got.Type() != want.Type() {
+			t.Fatalf("constant %d has wrong type. want=%s, got=%s",
+				i, want.Type(), got.Type())
+		}
+
+		switch want := want.(type) {
+		case *object.Integer:
+			err := testIntegerObject(want.Value, got)
+			if err != nil {
+				t.Errorf("constant %d - %s", i, err)
+			}
+		case *object.Float:
+			err := testFloatObject(want.Value, got)
+			if err != nil {
+				t.Errorf("constant %d - %s", i, err)
+			}
+		case *object.String:
+			err := testStringObject(want.Value, got)
+			if err != nil {
+				t.Errorf("constant %d - %s", i, err)
+			}
+		case *object.CompiledFunction:
+			fn := got.(*object.CompiledFunction)
+			if !bytes.Equal(fn.Instructions, want.Instructions) {
+				t.Errorf("constant %d has wrong instructions", i)
+			}
+			if fn.NumLocals != want.NumLocals || fn.NumParameters != want.NumParameters {
+				t.Errorf("constant %d has wrong locals/parameters. want=%d/%d, got=%d/%d",
+					i, want.NumLocals, want.NumParameters, fn.NumLocals, fn.NumParameters)
+			}
+			if fn.Name != want.Name || fn.Filename != want.Filename {
+				t.Errorf("constant %d has wrong debug info. want=%q/%q, got=%q/%q",
+					i, want.Name, want.Filename, fn.Name, fn.Filename)
+			}
+			testLineTable(t, want.Lines, fn.Lines)
+			testNames(t, want.LocalNames, fn.LocalNames)
+			testNames(t, want.FreeNames, fn.FreeNames)
+		}
+	}
+}
+
+func TestByteCodeLoadErrors(t *testing.T) {
+	compiler := New()
+	err := compiler.Compile(parse(`"a" + "b"`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	valid, err := compiler.ByteCode().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+
+	corrupt := func(f func(data []byte) []byte) []byte {
+		data := make([]byte, len(valid))
+		copy(data, valid)
+		return f(data)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"empty", []byte{}, ErrInvalidMagic},
+		{"magic", corrupt(func(d []byte) []byte { d[1] = 'X'; return d }), ErrInvalidMagic},
+		{"version", corrupt(func(d []byte) []byte { d[5] = 99; return d }), ErrUnsupportedVersion},
+		{"checksum", corrupt(func(d []byte) []byte { d[len(d)-1] ^= 0xff; return d }), ErrChecksumMismatch},
+	}
+
+	for _, tt := range tests {
+		_, err := ReadByteCode(bytes.NewReader(tt.data))
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. want=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+
+	_, err = ReadByteCode(bytes.NewReader(valid[:len(valid)-1]))
+	if err == nil {
+		t.Errorf("expected error for truncated bytecode")
+	}
+}
+
+func testNames(t *testing.T, expected, actual []string) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("wrong number of names. want=%q, got=%q", expected, actual)
+	}
+
+	for i, name := range expected {
+		if actual[i] != name {
+			t.Errorf("wrong name %d. want=%q, got=%q", i, name, actual[i])
+		}
+	}
+}
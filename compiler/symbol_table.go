@@ -20,8 +20,9 @@ type SymbolTable struct {
 	Outer       *SymbolTable
 	FreeSymbols []Symbol
 
-	store          map[string]Symbol
-	numDefinitions int
+	store           map[string]Symbol
+	numDefinitions  int
+	definitionNames []string
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -36,7 +37,14 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return s
 }
 
+// Define returns a new symbol for name. Redefining a name of the same scope
+// reuses its slot, so that `let x = x + 1;` reads the previous value.
 func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok &&
+		(symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+
 	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: GlobalScope}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
@@ -46,6 +54,7 @@ func (s *SymbolTable) Define(name string) Symbol {
 
 	s.store[name] = symbol
 	s.numDefinitions++
+	s.definitionNames = append(s.definitionNames, name)
 	return symbol
 }
 
@@ -88,3 +97,14 @@ func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 	s.store[name] = symbol
 	return symbol
 }
+
+// builtinTable returns a new table defining only the builtins of s.
+func (s *SymbolTable) builtinTable() *SymbolTable {
+	table := NewSymbolTable()
+	for name, symbol := range s.store {
+		if symbol.Scope == BuiltinScope {
+			table.store[name] = symbol
+		}
+	}
+	return table
+}
@@ -49,6 +49,32 @@ func TestDefine(t *testing.T) {
 	}
 }
 
+func TestRedefine(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	a := global.Define("a")
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+	if a != expected {
+		t.Errorf("expected a=%+v, got=%+v", expected, a)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	local.Resolve("b")
+
+	b := local.Define("b")
+	expected = Symbol{Name: "b", Scope: LocalScope, Index: 0}
+	if b != expected {
+		t.Errorf("expected b=%+v, got=%+v", expected, b)
+	}
+
+	if global.numDefinitions != 2 {
+		t.Errorf("wrong number of definitions. want=2, got=%d",
+			global.numDefinitions)
+	}
+}
+
 func TestResolveGlobal(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
@@ -0,0 +1,198 @@
+package disasm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"strings"
+)
+
+// Disassemble writes a listing of the main program followed by the listings
+// of all function constants to out.
+func Disassemble(out io.Writer, bytecode *compiler.ByteCode) error {
+	d := &disassembler{constants: bytecode.Constants}
+
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Name:         "<main>",
+		Filename:     bytecode.Filename,
+		Lines:        bytecode.Lines,
+	}
+	d.globals = bytecode.GlobalNames
+
+	d.function(mainFn, "== <main> ==")
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		header := fmt.Sprintf("== %s (constant %d, params=%d, locals=%d) ==",
+			functionName(fn), i, fn.NumParameters, fn.NumLocals)
+		d.out.WriteString("\n")
+		d.function(fn, header)
+	}
+
+	_, err := out.Write(d.out.Bytes())
+	return err
+}
+
+type disassembler struct {
+	out       bytes.Buffer
+	constants []object.Object
+	globals   []string
+}
+
+func (d *disassembler) function(fn *object.CompiledFunction, header string) {
+	d.out.WriteString(header + "\n")
+	if fn.Filename != "" {
+		d.out.WriteString("file: " + fn.Filename + "\n")
+	}
+
+	instr := fn.Instructions
+	targets := jumpTargets(instr)
+	lastLine := 0
+
+	i := 0
+	for i < len(instr) {
+		def, err := code.Lookup(instr[i])
+		if err != nil {
+			fmt.Fprintf(&d.out, "%04d ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(instr) {
+			fmt.Fprintf(&d.out, "%04d ERROR: truncated %s\n", i, def.Name)
+			break
+		}
+
+		operands, read := code.ReadOperands(def, instr[i+1:])
+
+		lineColumn := "    "
+		if line, ok := fn.Lines.Lookup(i); ok && line != lastLine {
+			lineColumn = fmt.Sprintf("%4d", line)
+			lastLine = line
+		}
+
+		marker := "  "
+		if targets[i] {
+			marker = ">>"
+		}
+
+		text := def.Name
+		for _, operand := range operands {
+			text += fmt.Sprintf(" %d", operand)
+		}
+
+		annotation := d.annotate(fn, code.OpCode(instr[i]), operands)
+		if annotation != "" {
+			text = fmt.Sprintf("%-24s ; %s", text, annotation)
+		}
+
+		fmt.Fprintf(&d.out, "%s %s %04d %s\n", lineColumn, marker, i, text)
+		i += 1 + read
+	}
+}
+
+func (d *disassembler) annotate(
+	fn *object.CompiledFunction,
+	op code.OpCode,
+	operands []int,
+) string {
+	switch op {
+	case code.OpConstant:
+		return d.constant(operands[0])
+	case code.OpClosure:
+		return fmt.Sprintf("%s, %d free", d.constant(operands[0]), operands[1])
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return name(d.globals, operands[0])
+	case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+		return name(fn.LocalNames, operands[0])
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		return name(fn.FreeNames, operands[0])
+	case code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			return object.Builtins[operands[0]].Name
+		}
+	case code.OpJump, code.OpJumpNotTruthy, code.OpSetupTry:
+		return fmt.Sprintf("-> %04d", operands[0])
+	case code.OpImport:
+		return fmt.Sprintf("%s, loaded -> %04d", name(d.globals, operands[0]), operands[1])
+	case code.OpCall, code.OpTailCall:
+		return fmt.Sprintf("%d args", operands[0])
+	}
+	return ""
+}
+
+func (d *disassembler) constant(index int) string {
+	if index >= len(d.constants) {
+		return "<invalid constant>"
+	}
+
+	switch constant := d.constants[index].(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", constant.Value)
+	case *object.CompiledFunction:
+		return functionName(constant)
+	default:
+		return constant.Inspect()
+	}
+}
+
+func name(names []string, index int) string {
+	if index < len(names) {
+		return names[index]
+	}
+	return ""
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "fn <anonymous>"
+	}
+	return "fn " + fn.Name
+}
+
+func jumpTargets(instr code.Instructions) map[int]bool {
+	targets := map[int]bool{}
+
+	i := 0
+	for i < len(instr) {
+		def, err := code.Lookup(instr[i])
+		if err != nil {
+			i++
+			continue
+		}
+
+		op := code.OpCode(instr[i])
+		isJump := op == code.OpJump || op == code.OpJumpNotTruthy || op == code.OpSetupTry
+		if isJump && i+3 <= len(instr) {
+			targets[int(code.ReadUint16(instr[i+1:]))] = true
+		}
+		if op == code.OpImport && i+5 <= len(instr) {
+			targets[int(code.ReadUint16(instr[i+3:]))] = true
+		}
+
+		for _, w := range def.OperandWidths {
+			i += w
+		}
+		i++
+	}
+
+	return targets
+}
+
+// String is a convenience wrapper around Disassemble.
+func String(bytecode *compiler.ByteCode) string {
+	var out strings.Builder
+	Disassemble(&out, bytecode)
+	return out.String()
+}
@@ -0,0 +1,59 @@
+package disasm
+
+import (
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let a = "x";
+let f = fn(n) {
+	if (n > 1) { n } else { len(a) }
+};
+f(2);`
+
+	expected := `== <main> ==
+file: test.monkey
+   1    0000 OpConstant 0             ; "x"
+        0003 OpSetGlobal 0            ; a
+   2    0006 OpClosure 2 0            ; fn f, 0 free
+        0010 OpSetGlobal 1            ; f
+   5    0013 OpGetGlobal 1            ; f
+        0016 OpConstant 3             ; 2
+        0019 OpCall 1                 ; 1 args
+        0021 OpPop
+
+== fn f (constant 2, params=1, locals=1) ==
+file: test.monkey
+   3    0000 OpGetLocal 0             ; n
+        0002 OpConstant 1             ; 1
+        0005 OpGreaterThan
+        0006 OpJumpNotTruthy 14       ; -> 0014
+        0009 OpGetLocal 0             ; n
+        0011 OpJump 21                ; -> 0021
+     >> 0014 OpGetBuiltin 0           ; len
+        0016 OpGetGlobal 0            ; a
+        0019 OpTailCall 1             ; 1 args
+     >> 0021 OpReturnValue
+`
+
+	lexer := lexer.NewWithFilename(input, "test.monkey")
+	parser := parser.New(lexer)
+	program := parser.ParseProgram()
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("parser errors: %q", parser.Errors())
+	}
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	actual := String(comp.ByteCode())
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
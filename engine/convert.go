@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"fmt"
+	"monkey/object"
+	"monkey/vm"
+	"reflect"
+	"sort"
+)
+
+// ToObject converts a Go value to a Monkey object. Supported are nil, bools,
+// integers, floats, strings, slices, arrays and maps with string, integer or
+// bool keys. Objects are returned unchanged.
+func ToObject(value any) (object.Object, error) {
+	switch value := value.(type) {
+	case nil:
+		return vm.Null, nil
+	case object.Object:
+		return value, nil
+	case bool:
+		return nativeBool(value), nil
+	case string:
+		return &object.String{Value: value}, nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.Bool:
+		return nativeBool(v.Bool()), nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+
+	case reflect.Slice, reflect.Array:
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			element, err := ToObject(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+
+	case reflect.Map:
+		// Go maps are unordered, insert the keys sorted to get a
+		// deterministic order
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+
+		hash := object.NewHash()
+		for _, k := range keys {
+			key, err := ToObject(k.Interface())
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := ToObject(v.MapIndex(k).Interface())
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(hashable, value)
+		}
+		return hash, nil
+
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return vm.Null, nil
+		}
+		return ToObject(v.Elem().Interface())
+	}
+
+	return nil, fmt.Errorf("cannot convert %T to an object", value)
+}
+
+// FromObject converts a Monkey object to a Go value. Integers become int64,
+// floats float64, arrays []any and hashes map[string]any, whose keys are the
+// Inspect() output of the Monkey keys. Functions are returned unchanged so
+// they can be passed to Engine.Call.
+func FromObject(obj object.Object) (any, error) {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.Float:
+		return obj.Value, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+
+	case *object.Array:
+		elements := make([]any, len(obj.Elements))
+		for i, element := range obj.Elements {
+			value, err := FromObject(element)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = value
+		}
+		return elements, nil
+
+	case *object.Hash:
+		m := make(map[string]any, obj.Len())
+		for _, pair := range obj.Pairs() {
+			key := pair.Key.Inspect()
+			if s, ok := pair.Key.(*object.String); ok {
+				key = s.Value
+			}
+			value, err := FromObject(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+
+	case *object.Closure, *object.Builtin:
+		return obj, nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a Go value", obj.Type())
+}
+
+// Int returns the value of an integer object.
+func Int(obj object.Object) (int64, error) {
+	i, ok := obj.(*object.Integer)
+	if !ok {
+		return 0, typeError("INTEGER", obj)
+	}
+	return i.Value, nil
+}
+
+// Float returns the value of a float or integer object.
+func Float(obj object.Object) (float64, error) {
+	switch obj := obj.(type) {
+	case *object.Float:
+		return obj.Value, nil
+	case *object.Integer:
+		return float64(obj.Value), nil
+	}
+	return 0, typeError("FLOAT", obj)
+}
+
+// String returns the value of a string object.
+func String(obj object.Object) (string, error) {
+	s, ok := obj.(*object.String)
+	if !ok {
+		return "", typeError("STRING", obj)
+	}
+	return s.Value, nil
+}
+
+// Bool returns the value of a boolean object.
+func Bool(obj object.Object) (bool, error) {
+	b, ok := obj.(*object.Boolean)
+	if !ok {
+		return false, typeError("BOOLEAN", obj)
+	}
+	return b.Value, nil
+}
+
+func typeError(want string, obj object.Object) error {
+	if obj == nil {
+		return fmt.Errorf("expected %s, got nil", want)
+	}
+	return fmt.Errorf("expected %s, got %s", want, obj.Type())
+}
+
+func nativeBool(value bool) *object.Boolean {
+	if value {
+		return vm.True
+	}
+	return vm.False
+}
@@ -0,0 +1,210 @@
+// Package engine embeds Monkey into Go programs. An Engine compiles and runs
+// source with its own set of host functions and globals, and lets Go code
+// call the closures a script returns.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/limit"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+)
+
+// maxBuiltins is the number of builtins OpGetBuiltin can address.
+const maxBuiltins = 256
+
+// Function is a host function callable from Monkey code.
+type Function struct {
+	Name string
+	// Arity is the number of arguments the function takes, -1 accepts any.
+	Arity int
+	Doc   string
+	// Fn implements the function. A returned error is raised as a Monkey
+	// exception, which scripts can catch.
+	Fn func(args ...object.Object) (object.Object, error)
+}
+
+// Engine holds the state of a Monkey program across multiple calls to Run.
+// It is not safe for concurrent use.
+type Engine struct {
+	functions   []Function
+	builtins    []*object.Builtin
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+	limits      limit.Limits
+	io          object.IO
+
+	// machine is the VM of the last Run, closures returned by it refer to
+	// its constants
+	machine *vm.VM
+}
+
+// New returns an Engine with the default builtins and functions.
+func New(functions ...Function) (*Engine, error) {
+	e := &Engine{
+		builtins:    vm.DefaultBuiltins(),
+		symbolTable: compiler.NewSymbolTable(),
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalSize),
+		io:          object.StandardIO(),
+	}
+	for i, def := range object.Builtins {
+		e.symbolTable.DefineBuiltin(i, def.Name)
+	}
+
+	for _, fn := range functions {
+		err := e.Register(fn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// Register makes fn available to the code run afterwards.
+func (e *Engine) Register(fn Function) error {
+	if fn.Name == "" {
+		return errors.New("function without name")
+	}
+	if fn.Fn == nil {
+		return fmt.Errorf("function %s has no implementation", fn.Name)
+	}
+	if _, ok := e.symbolTable.Resolve(fn.Name); ok {
+		return fmt.Errorf("function %s is already defined", fn.Name)
+	}
+	if len(e.builtins) >= maxBuiltins {
+		return fmt.Errorf("function %s exceeds the limit of %d builtins", fn.Name, maxBuiltins)
+	}
+
+	e.symbolTable.DefineBuiltin(len(e.builtins), fn.Name)
+	e.builtins = append(e.builtins, &object.Builtin{Fn: wrap(fn)})
+	e.functions = append(e.functions, fn)
+	return nil
+}
+
+// Functions returns the registered host functions.
+func (e *Engine) Functions() []Function {
+	return append([]Function(nil), e.functions...)
+}
+
+// wrap adapts fn to the builtin calling convention.
+func wrap(fn Function) object.BuiltinFunction {
+	return func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+		if fn.Arity >= 0 && len(args) != fn.Arity {
+			return &object.Error{Message: fmt.Sprintf(
+				"wrong number of arguments. got=%d, want=%d", len(args), fn.Arity)}
+		}
+
+		result, err := fn.Fn(args...)
+		if limit.IsStop(err) {
+			// the host function was stopped with the script calling it
+			return &object.Error{Message: err.Error(), Stop: err}
+		}
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		if result == nil {
+			return vm.Null
+		}
+		return result
+	}
+}
+
+// SetIO sets the streams used by the scripts run afterwards, for example by
+// puts. The default is object.StandardIO.
+func (e *Engine) SetIO(io object.IO) {
+	e.io = io
+}
+
+// SetLimits sets the limits of every Run and Call afterwards.
+func (e *Engine) SetLimits(limits limit.Limits) {
+	e.limits = limits
+}
+
+// Run compiles and executes source and returns the value of its last
+// expression statement. Globals defined by earlier runs stay visible.
+func (e *Engine) Run(source string) (object.Object, error) {
+	return e.RunContext(context.Background(), source)
+}
+
+// RunContext runs source like Run, but stops it once ctx is done. The error
+// of a stopped program is a *limit.StopError.
+func (e *Engine) RunContext(ctx context.Context, source string) (object.Object, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		errs := make([]error, len(p.Errors()))
+		for i, msg := range p.Errors() {
+			errs[i] = errors.New(msg)
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	comp := compiler.NewWithState(e.symbolTable, e.constants)
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	bytecode := comp.ByteCode()
+	e.constants = bytecode.Constants
+
+	e.machine = vm.NewWithState(bytecode, e.globals, e.builtins)
+	e.machine.SetLimits(e.limits)
+	e.machine.SetIO(e.io)
+	err = e.machine.RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := e.machine.LastPoppedStackElement()
+	if result == nil {
+		return vm.Null, nil
+	}
+	return result, nil
+}
+
+// Get returns the value of the global name.
+func (e *Engine) Get(name string) (object.Object, bool) {
+	symbol, ok := e.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return nil, false
+	}
+	value := e.globals[symbol.Index]
+	if value == nil {
+		return vm.Null, true
+	}
+	return value, true
+}
+
+// Set defines the global name with value, the code run afterwards can use it
+// like a variable bound with let.
+func (e *Engine) Set(name string, value object.Object) {
+	symbol := e.symbolTable.Define(name)
+	e.globals[symbol.Index] = value
+}
+
+// Call calls fn, a closure or builtin, with args. It can be used by host
+// functions while a script is running as well as after Run returned.
+func (e *Engine) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	return e.CallContext(context.Background(), fn, args...)
+}
+
+// CallContext calls fn like Call, but stops it once ctx is done. Calls made
+// by host functions while a script is running are stopped with the script.
+func (e *Engine) CallContext(ctx context.Context, fn object.Object, args ...object.Object) (object.Object, error) {
+	if e.machine == nil {
+		bytecode := &compiler.ByteCode{Constants: e.constants}
+		e.machine = vm.NewWithState(bytecode, e.globals, e.builtins)
+	}
+	e.machine.SetLimits(e.limits)
+	e.machine.SetIO(e.io)
+	return e.machine.CallContext(ctx, fn, args...)
+}
@@ -0,0 +1,360 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"monkey/limit"
+	"monkey/object"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostFunctions(t *testing.T) {
+	double := Function{
+		Name:  "double",
+		Arity: 1,
+		Doc:   "double(x) returns x * 2",
+		Fn: func(args ...object.Object) (object.Object, error) {
+			x, err := Int(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return ToObject(x * 2)
+		},
+	}
+	fail := Function{
+		Name:  "fail",
+		Arity: -1,
+		Fn: func(args ...object.Object) (object.Object, error) {
+			return nil, errors.New("host failure")
+		},
+	}
+
+	e, err := New(double, fail)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"double(21)", int64(42)},
+		{"let f = fn(x) { double(x) + 1 }; f(2)", int64(5)},
+		{`try { fail(1, 2) } catch (e) { e["message"] }`, "host failure"},
+		{`try { double() } catch (e) { e["message"] }`,
+			"wrong number of arguments. got=0, want=1"},
+		{`try { double("a") } catch (e) { e["message"] }`,
+			"expected INTEGER, got STRING"},
+		{"len([1, 2])", int64(2)},
+	}
+
+	for _, tt := range tests {
+		result, err := e.Run(tt.input)
+		if err != nil {
+			t.Fatalf("%q: Run failed: %s", tt.input, err)
+		}
+		value, err := FromObject(result)
+		if err != nil {
+			t.Fatalf("%q: FromObject failed: %s", tt.input, err)
+		}
+		if value != tt.expected {
+			t.Errorf("%q: wrong result. want=%v, got=%v", tt.input, tt.expected, value)
+		}
+	}
+
+	if len(e.Functions()) != 2 {
+		t.Errorf("wrong number of functions. want=2, got=%d", len(e.Functions()))
+	}
+}
+
+func TestFunctionsArePerEngine(t *testing.T) {
+	answer := Function{
+		Name: "answer",
+		Fn: func(args ...object.Object) (object.Object, error) {
+			return ToObject(42)
+		},
+	}
+
+	withAnswer, err := New(answer)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if _, err := withAnswer.Run("answer()"); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+
+	without, err := New()
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	_, err = without.Run("answer()")
+	if err == nil || !strings.Contains(err.Error(), "undefined variable answer") {
+		t.Fatalf("expected undefined variable error, got %v", err)
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	noop := func(args ...object.Object) (object.Object, error) { return nil, nil }
+
+	tests := []struct {
+		fn       Function
+		expected string
+	}{
+		{Function{Fn: noop}, "function without name"},
+		{Function{Name: "f"}, "function f has no implementation"},
+		{Function{Name: "len", Fn: noop}, "function len is already defined"},
+	}
+
+	for _, tt := range tests {
+		e, err := New()
+		if err != nil {
+			t.Fatalf("New failed: %s", err)
+		}
+		err = e.Register(tt.fn)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	e, err := New()
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+
+	result, err := e.Run(`
+	let offset = 10;
+	let counter = 0;
+	let add = fn(a, b) { counter += 1; a + b + offset };
+	add`)
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		sum, err := e.Call(result, &object.Integer{Value: i}, &object.Integer{Value: 2})
+		if err != nil {
+			t.Fatalf("Call failed: %s", err)
+		}
+		if got, _ := Int(sum); got != i+12 {
+			t.Errorf("wrong sum. want=%d, got=%d", i+12, got)
+		}
+	}
+
+	counter, ok := e.Get("counter")
+	if !ok {
+		t.Fatalf("counter not defined")
+	}
+	if got, _ := Int(counter); got != 3 {
+		t.Errorf("wrong counter. want=3, got=%d", got)
+	}
+
+	_, err = e.Call(result, &object.Integer{Value: 1})
+	if err == nil || !strings.Contains(err.Error(), "wrong number of arguments") {
+		t.Errorf("expected arity error, got %v", err)
+	}
+
+	fails, err := e.Run(`fn() { throw "boom" }`)
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	_, err = e.Call(fails)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected uncaught exception, got %v", err)
+	}
+
+	// the engine is still usable after a failed call
+	sum, err := e.Call(result, &object.Integer{Value: 0}, &object.Integer{Value: 0})
+	if err != nil {
+		t.Fatalf("Call failed: %s", err)
+	}
+	if got, _ := Int(sum); got != 10 {
+		t.Errorf("wrong sum. want=10, got=%d", got)
+	}
+}
+
+func TestCallFromHostFunction(t *testing.T) {
+	var e *Engine
+	apply := Function{
+		Name:  "apply",
+		Arity: 2,
+		Fn: func(args ...object.Object) (object.Object, error) {
+			return e.Call(args[0], args[1])
+		},
+	}
+
+	e, err := New(apply)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"apply(fn(x) { x * 3 }, 4)", 12},
+		{"let f = fn(x) { apply(fn(y) { y + x }, x) }; f(5) + 1", 11},
+		{"apply(fn(x) { try { throw x } catch (e) { return 7 } }, 1)", 7},
+		{`try { apply(fn(x) { throw "inner" }, 1) } catch (e) { 8 }`, 8},
+		{"apply(len, [1, 2, 3])", 3},
+	}
+
+	for _, tt := range tests {
+		result, err := e.Run(tt.input)
+		if err != nil {
+			t.Fatalf("%q: Run failed: %s", tt.input, err)
+		}
+		if got, err := Int(result); err != nil || got != tt.expected {
+			t.Errorf("%q: wrong result. want=%d, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	var e *Engine
+	apply := Function{
+		Name:  "apply",
+		Arity: 1,
+		Fn: func(args ...object.Object) (object.Object, error) {
+			return e.Call(args[0])
+		},
+	}
+
+	e, err := New(apply)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	e.SetLimits(limit.Limits{MaxInstructions: 10000})
+
+	_, err = e.Run("while (true) {}")
+	if !errors.Is(err, limit.ErrInstructions) {
+		t.Errorf("wrong error. want=%q, got=%v", limit.ErrInstructions, err)
+	}
+
+	// host functions can't catch the error either
+	_, err = e.Run("try { apply(fn() { while (true) {} }) } catch (e) {}; 1")
+	if !errors.Is(err, limit.ErrInstructions) {
+		t.Errorf("wrong error. want=%q, got=%v", limit.ErrInstructions, err)
+	}
+
+	// every run starts counting anew
+	loop, err := e.Run("let i = 0; while (i < 100) { i += 1 }; fn() { while (true) {} }")
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+
+	e.SetLimits(limit.Limits{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = e.CallContext(ctx, loop)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wrong error. want=%q, got=%v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestIO(t *testing.T) {
+	engines := make([]*Engine, 2)
+	outputs := make([]bytes.Buffer, 2)
+	for i := range engines {
+		e, err := New()
+		if err != nil {
+			t.Fatalf("New failed: %s", err)
+		}
+		e.SetIO(object.IO{Stdout: &outputs[i], Stderr: &outputs[i]})
+		engines[i] = e
+	}
+
+	say, err := engines[0].Run(`puts("first"); fn(x) { puts(x) }`)
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	_, err = engines[1].Run(`puts("second")`)
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	_, err = engines[0].Call(say, &object.String{Value: "again"})
+	if err != nil {
+		t.Fatalf("Call failed: %s", err)
+	}
+
+	expected := []string{"first\nagain\n", "second\n"}
+	for i, out := range outputs {
+		if out.String() != expected[i] {
+			t.Errorf("wrong output of engine %d. want=%q, got=%q", i, expected[i], out.String())
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	e, err := New()
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+
+	limit, _ := ToObject(100)
+	e.Set("limit", limit)
+
+	result, err := e.Run("let doubled = limit * 2; doubled")
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	if got, _ := Int(result); got != 200 {
+		t.Errorf("wrong result. want=200, got=%d", got)
+	}
+
+	doubled, ok := e.Get("doubled")
+	if !ok {
+		t.Fatalf("doubled not defined")
+	}
+	if got, _ := Int(doubled); got != 200 {
+		t.Errorf("wrong global. want=200, got=%d", got)
+	}
+
+	if _, ok := e.Get("missing"); ok {
+		t.Errorf("expected missing to be undefined")
+	}
+}
+
+func TestConversion(t *testing.T) {
+	tests := []struct {
+		input    any
+		expected any
+	}{
+		{nil, nil},
+		{true, true},
+		{3, int64(3)},
+		{uint8(3), int64(3)},
+		{2.5, 2.5},
+		{"monkey", "monkey"},
+		{[]int{1, 2}, []any{int64(1), int64(2)}},
+		{map[string]any{"a": 1, "b": []string{"c"}},
+			map[string]any{"a": int64(1), "b": []any{"c"}}},
+		{map[int]bool{1: true}, map[string]any{"1": true}},
+	}
+
+	for _, tt := range tests {
+		obj, err := ToObject(tt.input)
+		if err != nil {
+			t.Fatalf("ToObject(%v) failed: %s", tt.input, err)
+		}
+		value, err := FromObject(obj)
+		if err != nil {
+			t.Fatalf("FromObject(%s) failed: %s", obj.Inspect(), err)
+		}
+		if !reflect.DeepEqual(value, tt.expected) {
+			t.Errorf("wrong value. want=%#v, got=%#v", tt.expected, value)
+		}
+	}
+
+	if _, err := ToObject(struct{}{}); err == nil {
+		t.Errorf("expected error converting a struct")
+	}
+	if _, err := ToObject(map[float32]int{}); err != nil {
+		t.Errorf("unexpected error converting an empty map: %s", err)
+	}
+}
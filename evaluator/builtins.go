@@ -11,4 +11,37 @@ var builtins = map[string]*object.Builtin{
 	"rest":  object.GetBuiltinByName("rest"),
 	"push":  object.GetBuiltinByName("push"),
 	"puts":  object.GetBuiltinByName("puts"),
+	"int":   object.GetBuiltinByName("int"),
+	"float": object.GetBuiltinByName("float"),
+
+	"map":    object.GetBuiltinByName("map"),
+	"filter": object.GetBuiltinByName("filter"),
+	"reduce": object.GetBuiltinByName("reduce"),
+	"each":   object.GetBuiltinByName("each"),
+	"sort":   object.GetBuiltinByName("sort"),
+	"find":   object.GetBuiltinByName("find"),
+	"any":    object.GetBuiltinByName("any"),
+	"all":    object.GetBuiltinByName("all"),
+
+	"split":       object.GetBuiltinByName("split"),
+	"join":        object.GetBuiltinByName("join"),
+	"trim":        object.GetBuiltinByName("trim"),
+	"upper":       object.GetBuiltinByName("upper"),
+	"lower":       object.GetBuiltinByName("lower"),
+	"replace":     object.GetBuiltinByName("replace"),
+	"contains":    object.GetBuiltinByName("contains"),
+	"starts_with": object.GetBuiltinByName("starts_with"),
+	"ends_with":   object.GetBuiltinByName("ends_with"),
+	"index_of":    object.GetBuiltinByName("index_of"),
+	"substr":      object.GetBuiltinByName("substr"),
+	"repeat":      object.GetBuiltinByName("repeat"),
+	"chars":       object.GetBuiltinByName("chars"),
+
+	"keys":    object.GetBuiltinByName("keys"),
+	"values":  object.GetBuiltinByName("values"),
+	"entries": object.GetBuiltinByName("entries"),
+	"has":     object.GetBuiltinByName("has"),
+	"delete":  object.GetBuiltinByName("delete"),
+	"remove":  object.GetBuiltinByName("remove"),
+	"merge":   object.GetBuiltinByName("merge"),
 }
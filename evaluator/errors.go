@@ -0,0 +1,35 @@
+package evaluator
+
+import (
+	"bytes"
+	"monkey/object"
+)
+
+// RuntimeError is a failed evaluation as a Go error, for hosts reporting
+// it. Trace holds the Monkey call stack at the time of the failure with the
+// innermost call first.
+type RuntimeError struct {
+	Message string
+	Trace   []string
+	// Err is the error stopping the program, if any
+	Err error
+}
+
+// NewRuntimeError returns the RuntimeError of err, the result of a failed
+// evaluation.
+func NewRuntimeError(err *object.Error) *RuntimeError {
+	return &RuntimeError{Message: err.Message, Trace: err.Trace, Err: err.Stop}
+}
+
+func (re *RuntimeError) Error() string { return re.Message }
+func (re *RuntimeError) Unwrap() error { return re.Err }
+
+func (re *RuntimeError) StackTrace() string {
+	var out bytes.Buffer
+
+	for _, entry := range re.Trace {
+		out.WriteString("\tat " + entry + "\n")
+	}
+
+	return out.String()
+}
@@ -1,18 +1,51 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
 	"monkey/ast"
+	"monkey/limit"
+	"monkey/module"
 	"monkey/object"
+	"monkey/token"
+	"strings"
 )
 
+// MaxCallDepth limits the nesting of function calls, deeper recursion
+// results in an error instead of exhausting the Go stack.
+const MaxCallDepth = 1024
+
 var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// EvalContext evaluates node like Eval, but stops the program once it exceeds
+// limits or ctx is done. The error of a stopped program has its Stop field
+// set to a *limit.StopError.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits limit.Limits) object.Object {
+	budget := env.Budget()
+	env.SetBudget(limit.NewBudget(ctx, limits))
+	defer env.SetBudget(budget)
+
+	return Eval(node, env)
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if err := env.Budget().Step(); err != nil {
+		return newStopError(err)
+	}
+
+	// errors are raised by the innermost node they come out of, which
+	// records the stack trace
+	return traced(eval(node, env), node.Pos(), env)
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
@@ -21,13 +54,16 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return allocated(&object.Integer{Value: node.Value}, env)
+
+	case *ast.FloatLiteral:
+		return allocated(&object.Float{Value: node.Value}, env)
 
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return allocated(&object.String{Value: node.Value}, env)
 
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
@@ -38,14 +74,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return allocated(evalInfixExpression(node.Operator, left, right), env)
 
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return allocated(evalPrefixExpression(node.Operator, right), env)
 
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
@@ -63,10 +99,41 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
+
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		// rethrown exceptions keep the trace of where they were raised
+		exception := object.NewException(val)
+		err := newError("%s", exception.Message)
+		err.Trace = exception.Trace
+		err.Thrown = exception.Value
+		return err
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		fn := &object.Function{Name: node.Name, Parameters: params, Env: env, Body: body}
+		return allocated(fn, env)
 
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
@@ -78,14 +145,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		return applyFunction(function, args, env, node.Pos())
 
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocated(&object.Array{Elements: elements}, env)
 
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
@@ -96,17 +163,38 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(index) {
 			return index
 		}
+		if left.Type() == object.STRING_OBJ {
+			// indexing a string creates the character
+			return allocated(evalIndexExpression(left, index), env)
+		}
 		return evalIndexExpression(left, index)
 
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return allocated(evalHashLiteral(node, env), env)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := evalTail(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+
+	case *ast.ImportStatement:
+		exports := evalImportStatement(node, env)
+		if isError(exports) {
+			return exports
+		}
+		env.Set(node.Alias.Value, exports)
+
+	case *ast.ExportStatement:
+		return Eval(node.Statement, env)
+
+	case *ast.MemberExpression:
+		obj := Eval(node.Object, env)
+		if isError(obj) {
+			return obj
+		}
+		return evalIndexExpression(obj, &object.String{Value: node.Property.Value})
 	}
 
 	return nil
@@ -120,29 +208,343 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
-			return result.Value
+			return unwrapReturnValue(completeTailCall(result, env))
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			return newError("%s outside of loop", result.Inspect())
 		}
 	}
 
 	return result
 }
 
+// evalImportStatement returns the exports of the module imported by is. A
+// module is evaluated the first time it is imported, in an environment of
+// its own.
+func evalImportStatement(is *ast.ImportStatement, env *object.Environment) object.Object {
+	modules := env.Modules()
+
+	path, err := module.Resolve(is.Path.Value, is.Pos().Filename, modules.SearchPath)
+	if err != nil {
+		return newError("%s", err)
+	}
+	if exports, ok := modules.Loaded[path]; ok {
+		return exports
+	}
+
+	err = modules.Loading.Enter(path)
+	if err != nil {
+		return newError("%s", err)
+	}
+	defer modules.Loading.Leave()
+
+	program, err := module.Parse(path)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	moduleEnv := object.NewModuleEnvironment(env, is.Pos())
+	result := evalProgram(program, moduleEnv)
+	if isError(result) {
+		return result
+	}
+
+	exports := object.NewHash()
+	for _, name := range module.Exports(program) {
+		// Go nils must not end up in the hash, should an export be unbound
+		value, ok := moduleEnv.Get(name)
+		if !ok {
+			value = NULL
+		}
+		exports.Set(&object.String{Value: name}, value)
+	}
+	modules.Loaded[path] = exports
+	return exports
+}
+
+// evalTail evaluates node in tail position, where the value of node is
+// returned from the function. Calls are not made but returned as
+// *object.TailCall, for applyFunction to make them.
+func evalTail(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		var result object.Object
+		for i, stmt := range node.Statements {
+			if i == len(node.Statements)-1 {
+				return evalTail(stmt, env)
+			}
+			result = Eval(stmt, env)
+			if isSignal(result) {
+				return result
+			}
+		}
+		return result
+
+	case *ast.ExpressionStatement:
+		return evalTail(node.Expression, env)
+
+	case *ast.IfExpression:
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if isTruthy(condition) {
+			return evalTail(node.Consequence, env)
+		} else if node.Alternative != nil {
+			return evalTail(node.Alternative, env)
+		}
+		return NULL
+
+	case *ast.CallExpression:
+		function := Eval(node.Function, env)
+		if isError(function) {
+			return function
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		return &object.TailCall{Fn: function, Args: args, Pos: node.Pos()}
+
+	default:
+		return Eval(node, env)
+	}
+}
+
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range block.Statements {
 		result = Eval(stmt, env)
 
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-				return result
+		if isSignal(result) {
+			return result
+		}
+	}
+
+	// empty blocks and blocks ending in a let statement have no value
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+func evalAssignExpression(ae *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := ae.Target.(type) {
+	case *ast.Identifier:
+		current, ok := env.Get(target.Value)
+		if !ok {
+			if _, ok := builtins[target.Value]; ok {
+				return newError("cannot assign to %s", target.Value)
 			}
+			return newError("identifier not found: " + target.Value)
+		}
+
+		value := Eval(ae.Value, env)
+		if isError(value) {
+			return value
+		}
+
+		if ae.Operator != "=" {
+			value = evalCompoundOperator(ae.Operator, current, value)
+			if isError(value) {
+				return value
+			}
+		}
+
+		if !env.Assign(target.Value, value) {
+			return newError("identifier not found: " + target.Value)
+		}
+		return value
+
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+		var current object.Object
+		if ae.Operator != "=" {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+
+		value := Eval(ae.Value, env)
+		if isError(value) {
+			return value
+		}
+
+		if ae.Operator != "=" {
+			value = evalCompoundOperator(ae.Operator, current, value)
+			if isError(value) {
+				return value
+			}
+		}
+
+		return evalSetIndexExpression(left, index, value)
+
+	default:
+		return newError("cannot assign to %s", ae.Target.String())
+	}
+}
+
+// evalCompoundOperator applies the operator of a compound assignment like
+// "+=" to the current and the assigned value.
+func evalCompoundOperator(operator string, current, value object.Object) object.Object {
+	return evalInfixExpression(strings.TrimSuffix(operator, "="), current, value)
+}
+
+func evalSetIndexExpression(left, index, value object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d", i.Value)
+		}
+		left.Elements[i.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Set(key, value)
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+
+	return value
+}
+
+// evalTryStatement catches errors of the try block. The result of the
+// finally block replaces the result of the statement if it returns, throws
+// or leaves a loop. Stopped programs skip both catch and finally blocks.
+func evalTryStatement(ts *ast.TryStatement, env *object.Environment) object.Object {
+	result := completeTailCall(Eval(ts.Block, env), env)
+	if isStop(result) {
+		return result
+	}
+
+	if err, ok := result.(*object.Error); ok && ts.Catch != nil {
+		exception := &object.Exception{Message: err.Message, Trace: err.Trace, Value: err.Thrown}
+		env.Set(ts.CatchParameter.Value, exception.Caught())
+		result = completeTailCall(Eval(ts.Catch, env), env)
+		if isStop(result) {
+			return result
+		}
+	}
+
+	if ts.Finally != nil {
+		finally := completeTailCall(Eval(ts.Finally, env), env)
+		if isSignal(finally) {
+			return finally
+		}
+	}
+
+	if isSignal(result) {
+		return result
+	}
+	return NULL
+}
+
+// completeTailCall makes the tail call returned by obj, if any. Returns out
+// of try statements complete their calls inside the statement, whose catch
+// and finally blocks have to see the result.
+func completeTailCall(obj object.Object, env *object.Environment) object.Object {
+	returnValue, ok := obj.(*object.ReturnValue)
+	if !ok {
+		return obj
+	}
+	call, ok := returnValue.Value.(*object.TailCall)
+	if !ok {
+		return obj
+	}
+
+	result := applyFunction(call.Fn, call.Args, env, call.Pos)
+	if isError(result) {
+		return result
+	}
+	return &object.ReturnValue{Value: result}
+}
+
+// isSignal reports whether obj unwinds the evaluation of statements.
+func isSignal(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	switch obj.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	default:
+		return false
+	}
+}
+
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			return NULL
 		}
+
+		result := Eval(ws.Body, env)
+		if isLoopExit(result) {
+			return loopResult(result)
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
 	}
 
+	iterator, ok := object.NewIterator(iterable)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+
+	for {
+		el, ok := iterator.Next()
+		if !ok {
+			return NULL
+		}
+
+		env.Set(fs.Variable.Value, el)
+
+		result := Eval(fs.Body, env)
+		if isLoopExit(result) {
+			return loopResult(result)
+		}
+	}
+}
+
+// isLoopExit reports whether the result of a loop body ends the loop.
+func isLoopExit(result object.Object) bool {
+	if result == nil {
+		return false
+	}
+	rt := result.Type()
+	return rt == object.BREAK_OBJ || rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ
+}
+
+func loopResult(result object.Object) object.Object {
+	if result.Type() == object.BREAK_OBJ {
+		return NULL
+	}
 	return result
 }
 
@@ -166,17 +568,19 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
-	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equals(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equals(left, right))
 	default:
 		return newError("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -184,25 +588,18 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
-	case TRUE:
-		return FALSE
-	case FALSE:
-		return TRUE
-	case NULL:
-		return TRUE
-	default:
-		return FALSE
-	}
+	return nativeBoolToBooleanObject(!isTruthy(right))
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
@@ -217,6 +614,9 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
@@ -236,6 +636,37 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
 func evalStringInfixExpression(
 	operator string,
 	left, right object.Object,
@@ -250,6 +681,14 @@ func evalStringInfixExpression(
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	default:
 		return newError("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -305,8 +744,16 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.ARRAY_OBJ:
+		return newError("array index must be INTEGER, got %s", index.Type())
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ:
+		return newError("string index must be INTEGER, got %s", index.Type())
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.EXCEPTION_OBJ:
+		return evalExceptionIndexExpression(left, index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -324,6 +771,15 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[i]
 }
 
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	char, ok := object.CharAt(str.(*object.String), index.(*object.Integer).Value)
+	if !ok {
+		return NULL
+	}
+
+	return char
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 	key, ok := index.(object.Hashable)
@@ -331,22 +787,35 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return value
 
 }
 
+func evalExceptionIndexExpression(exception, index object.Object) object.Object {
+	key, ok := index.(*object.String)
+	if !ok {
+		return NULL
+	}
+
+	field := exception.(*object.Exception).Get(key.Value)
+	if field == nil {
+		return NULL
+	}
+	return field
+}
+
 func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for k, v := range node.Pairs {
+	for _, k := range node.Keys {
 		key := Eval(k, env)
 		if isError(key) {
 			return key
@@ -356,30 +825,86 @@ func evalHashLiteral(
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(v, env)
+		value := Eval(node.Pairs[k], env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction calls fn from the caller environment at pos.
+func applyFunction(
+	fn object.Object,
+	args []object.Object,
+	caller *object.Environment,
+	pos token.Position,
+) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		// tail calls are made in this loop instead of nesting, so they
+		// don't count towards the call depth. The called function replaces
+		// the calling one, which raises the errors of the call at its
+		// position, like the VM does.
+		env, at := caller, pos
+		for {
+			if len(args) != len(fn.Parameters) {
+				err := newError("wrong number of arguments: want=%d, got=%d",
+					len(fn.Parameters), len(args))
+				return traced(err, at, env)
+			}
+			if caller.Depth() >= MaxCallDepth {
+				return traced(newError("maximum call depth exceeded"), at, env)
+			}
+
+			extendedEnv := extendFunctionEnv(fn, args, caller, pos)
+			result := unwrapReturnValue(evalTail(fn.Body, extendedEnv))
+
+			call, ok := result.(*object.TailCall)
+			if !ok {
+				return result
+			}
+			next, ok := call.Fn.(*object.Function)
+			if !ok {
+				// builtins don't replace the calling function
+				result := applyFunction(call.Fn, call.Args, extendedEnv, call.Pos)
+				return traced(result, call.Pos, extendedEnv)
+			}
+			fn, args = next, call.Args
+			env, at = extendedEnv, call.Pos
+		}
 
 	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
-			return result
+		// builtins may swallow the errors of their callbacks, but not the
+		// error stopping the program
+		var stop *object.Error
+		var reserved int64
+		ctx := &object.BuiltinContext{
+			Call: func(fn object.Object, args ...object.Object) object.Object {
+				result := applyFunction(fn, args, caller, pos)
+				if isStop(result) {
+					stop = result.(*object.Error)
+				}
+				return result
+			},
+			Allocate: func(size int64) error {
+				reserved += size
+				return caller.Budget().Allocate(size)
+			},
+			IO: caller.IO(),
 		}
-		return NULL
+		result := fn.Fn(ctx, args...)
+		if stop != nil {
+			return stop
+		}
+		if result == nil {
+			return NULL
+		}
+		// what the builtin reserved is already counted
+		return allocatedSize(result, object.Size(result)-reserved, caller)
 
 	default:
 		return newError("not a function: %s", fn.Type())
@@ -389,8 +914,15 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
+	caller *object.Environment,
+	pos token.Position,
 ) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	name := fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	frame := &object.Frame{Function: name, Caller: caller, CallPos: pos}
+	env := object.NewCallEnvironment(fn.Env, frame)
 
 	for i, p := range fn.Parameters {
 		env.Set(p.Value, args[i])
@@ -400,19 +932,45 @@ func extendFunctionEnv(
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
-	if returnValue, ok := obj.(*object.ReturnValue); ok {
-		return returnValue.Value
+	switch obj := obj.(type) {
+	case nil:
+		// the body of the function has no value
+		return NULL
+	case *object.ReturnValue:
+		return obj.Value
+	case *object.Break, *object.Continue:
+		return newError("%s outside of loop", obj.Inspect())
 	}
 	return obj
 }
 
-func isTruthy(obj object.Object) bool {
-	switch obj {
-	case NULL:
-		return false
-	case TRUE:
+func isNumber(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Integer, *object.Float:
 		return true
-	case FALSE:
+	default:
+		return false
+	}
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
+// isTruthy checks the type rather than comparing to the singletons, builtins
+// return booleans and nulls of their own.
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
 		return false
 	default:
 		return true
@@ -423,6 +981,77 @@ func newError(format string, a ...any) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// traced records the stack trace of result if it is an error raised at pos
+// in env, which has none yet.
+func traced(result object.Object, pos token.Position, env *object.Environment) object.Object {
+	if err, ok := result.(*object.Error); ok && err.Trace == nil && err.Stop == nil {
+		err.Trace = stackTrace(pos, env)
+	}
+	return result
+}
+
+// stackTrace returns the calls leading to pos in env, innermost first, in
+// the format of the VM's stack traces.
+func stackTrace(pos token.Position, env *object.Environment) []string {
+	var trace []string
+
+	for {
+		frame := env.Frame()
+		function := "<main>"
+		if frame != nil {
+			function = frame.Function
+		}
+		trace = append(trace, traceEntry(function, pos))
+
+		if frame == nil {
+			return trace
+		}
+		pos, env = frame.CallPos, frame.Caller
+	}
+}
+
+func traceEntry(function string, pos token.Position) string {
+	location := pos.Filename
+	if location == "" {
+		location = "<input>"
+	}
+	if pos.IsValid() {
+		location = fmt.Sprintf("%s:%d", location, pos.Line)
+	}
+	return fmt.Sprintf("%s (%s)", function, location)
+}
+
+func newStopError(err error) *object.Error {
+	return &object.Error{Message: err.Error(), Stop: err}
+}
+
+// isStop reports whether obj is an error stopping the program.
+func isStop(obj object.Object) bool {
+	err, ok := obj.(*object.Error)
+	return ok && err.Stop != nil
+}
+
+// allocated counts obj, which was just created, against the allocation
+// limit of the program. Errors and the shared singletons are not counted.
+func allocated(obj object.Object, env *object.Environment) object.Object {
+	return allocatedSize(obj, object.Size(obj), env)
+}
+
+// allocatedSize is like allocated, but counts obj with the given size.
+func allocatedSize(obj object.Object, size int64, env *object.Environment) object.Object {
+	switch obj {
+	case NULL, TRUE, FALSE:
+		return obj
+	}
+	if isError(obj) || size <= 0 {
+		return obj
+	}
+	if err := env.Budget().Allocate(size); err != nil {
+		return newStopError(err)
+	}
+	return obj
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
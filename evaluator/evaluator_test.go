@@ -1,10 +1,18 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"monkey/lexer"
+	"monkey/limit"
 	"monkey/object"
 	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -35,6 +43,38 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"1 / 4.0", 0.25},
+		{"1 < 1.5", true},
+		{"2.0 == 2", true},
+		{"2.5 != 2.5", false},
+		{"int(2.7) + 1", 3},
+		{"float(2) / 4", 0.5},
+		{"{2: 1}[2.0]", 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		}
+	}
+}
+
 func TestEvalBooleanExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -70,6 +110,15 @@ func TestEvalBooleanExpressions(t *testing.T) {
 		{`"hello world" != "hello world"`, false},
 		{`"Hello World" == "hello world"`, false},
 		{`"Hello World" != "hello world"`, true},
+		{`"a" == "a"`, true},
+		{`"a" < "b"`, true},
+		{`"b" <= "a"`, false},
+		{`"abc" > "abd"`, false},
+		{`"b" >= "b"`, true},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] != [1, 3]", true},
+		{`{"a": [1]} == {"a": [1]}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
 	}
 
 	for _, tt := range tests {
@@ -154,6 +203,7 @@ func TestErrorHandling(t *testing.T) {
 	}{
 		{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
 		{"5 + true; 5;", "type mismatch: INTEGER + BOOLEAN"},
+		{"1 + fn() {}();", "type mismatch: INTEGER + NULL"},
 		{"-true;", "unknown operator: -BOOLEAN"},
 		{"true + false;", "unknown operator: BOOLEAN + BOOLEAN"},
 		{"5; true + false; 5", "unknown operator: BOOLEAN + BOOLEAN"},
@@ -168,6 +218,18 @@ if (10 > 1) {
 		{"foobar", "identifier not found: foobar"},
 		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
 		{`{"name": "Monkey"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
+		{"for (x in 1) { x }", "cannot iterate over INTEGER"},
+		{"break;", "break outside of loop"},
+		{"x = 1;", "identifier not found: x"},
+		{"len = 1;", "cannot assign to len"},
+		{"len += 1;", "cannot assign to len"},
+		{"1 / 0", "division by zero"},
+		{`[1, 2]["a"]`, "array index must be INTEGER, got STRING"},
+		{"fn(x) { x }()", "wrong number of arguments: want=1, got=0"},
+		{"let f = fn() { 1 + f() }; f();", "maximum call depth exceeded"},
+		{"let a = [1]; a[1] = 2;", "index out of range: 1"},
+		{`let s = "abc"; s[0] = "x";`, "index assignment not supported: STRING"},
+		{"while (true) { fn() { continue; }() }", "continue outside of loop"},
 	}
 
 	for _, tt := range tests {
@@ -187,6 +249,112 @@ if (10 > 1) {
 	}
 }
 
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"let i = 0; let s = 0; while (i < 10) { let i = i + 1; let s = s + i; }; s;", 55},
+		{"let i = 0; while (true) { let i = i + 1; if (i == 5) { break; } }; i;", 5},
+		{"let f = fn() { while (true) { return 7; } }; f();", 7},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; }; let s = s + x; }; s;", 8},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; }; let s = s + x; }; s;", 3},
+		{"let s = 0; for (k in {1: 2, 3: 4}) { let s = s + k; }; s;", 4},
+		{"while (false) { 1 }", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; x = 2; x;", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4;", 6},
+		{"let x = 1; let y = 2; x = y = 3; x + y;", 6},
+		{"let a = [1, 2, 3]; a[2] += 10; a[2];", 13},
+		{"let a = [1]; let b = a; b[0] = 2; a[0];", 2},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 3; h["a"] + h["b"];`, 5},
+		{"let i = 0; let s = 0; while (i < 5) { i += 1; s += i; }; s;", 15},
+		{"let counter = fn() { let c = 0; fn() { c += 1 } }; let c = counter(); c(); c(); c();", 3},
+		{"let x = 1; let f = fn() { x = 5 }; f(); x;", 5},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`let r = ""; try { throw "boom"; } catch (e) { r = e["message"]; }; r;`, "boom"},
+		{`let r = ""; try { len(1) } catch (e) { r = e["message"] }; r;`,
+			"argument to `len` not supported, got INTEGER"},
+		{`let r = ""; try { 1 / 0 } catch (e) { r = e["message"] }; r;`, "division by zero"},
+		{`let f = fn() { throw "inner" }; let r = ""; try { f() } catch (e) { r = e["message"] }; r;`, "inner"},
+		{`let r = 1 + fn() { try { throw "x" } catch (e) { return 2 } }(); r;`, 3},
+		{`let n = 0; try { throw "x" } catch (e) { n += 1 } finally { n += 10 }; n;`, 11},
+		{`let n = 0; let f = fn() { try { return 1 } finally { n += 10 } }; f() + n;`, 11},
+		{`let n = 0; while (true) { try { break; } finally { n += 1 } }; n;`, 1},
+		{`let r = ""; try { try { throw "a" } finally { 1 } } catch (e) { r = e["message"] }; r;`, "a"},
+		{`let r = 0; try { throw 42 } catch (e) { r = e }; r;`, 42},
+		{`let r = 0; try { throw {"code": 1} } catch (e) { r = e["code"] }; r;`, 1},
+		{`let r = 0; try { try { throw [7] } catch (e) { throw e } } catch (e) { r = e[0] }; r;`, 7},
+		{`throw 42;`, &object.Error{Message: "42"}},
+		{`let f = fn() { throw "x" };
+		let t = [];
+		try { f() } catch (e) { t = e["trace"] };
+		join(t, ", ");`, "f (<input>:1), <main> (<input>:3)"},
+		{`let f = fn() { [1][0]["a"] }; let t = "";
+		try { f() } catch (e) { try { throw e } catch (e) { t = e["trace"][0] } }; t;`,
+			"f (<input>:1)"},
+		{`throw "uncaught";`, &object.Error{Message: "uncaught"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. want=%q, got=%q", expected, str.Value)
+			}
+		case *object.Error:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if err.Message != expected.Message {
+				t.Errorf("wrong error message. want=%q, got=%q", expected.Message, err.Message)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -245,6 +413,23 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestFunctionsWithoutReturnStatement(t *testing.T) {
+	tests := []string{
+		"let a = fn() {}; a();",
+		"let a = fn() {}; let b = fn() { a(); }; a(); b();",
+		"let f = fn() { let y = 1; }; f();",
+		"let a = fn() {}(); a;",
+		"[fn() {}()][0];",
+		`{"a": fn() {}()}["a"];`,
+		"let x = 0; x = fn() {}(); x;",
+		"if (true) {};",
+	}
+
+	for _, input := range tests {
+		testNullObject(t, testEval(input))
+	}
+}
+
 func TestClosures(t *testing.T) {
 	input := `
 let newAdder = fn(x) {
@@ -257,6 +442,48 @@ addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		// far deeper than MaxCallDepth
+		{`let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } };
+		sum(100000, 0)`, 5000050000},
+		{`let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); };
+		sum(100000, 0)`, 5000050000},
+		{`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+		let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+		even(10001)`, false},
+		{`let count = fn(a) { len(a) }; count([1, 2])`, 2},
+		{`return len([1]);`, 1},
+		{`let fail = fn() { throw "boom" };
+		let f = fn() { try { return fail(); } catch (e) { return e["message"]; } };
+		f()`, "boom"},
+		{`let f = fn() { g() }; let g = fn() { 1 / 0 }; f()`, &object.Error{Message: "division by zero"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("object is not %q. got=%T (%+v)", expected, evaluated, evaluated)
+			}
+		case *object.Error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected.Message {
+				t.Errorf("object is not error %q. got=%T (%+v)", expected.Message, evaluated, evaluated)
+			}
+		}
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
@@ -306,6 +533,36 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`rest([])`, nil},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map([1], len)`, "argument to `len` not supported, got INTEGER"},
+		{`map([1], 1)`, "argument to `map` must be a function, got INTEGER"},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`reduce([1, 2, 3, 4], 10, fn(acc, x) { acc + x })`, 20},
+		{`let sum = 0; each([1, 2, 3], fn(x) { sum += x }); sum`, 6},
+		{`sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+		{`sort([3, 1, 2], fn(a, b) { a - b })`, []int{1, 2, 3}},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, 2},
+		{`find([1, 2, 3], fn(x) { x > 3 })`, nil},
+		{`any([1, 2, 3], fn(x) { x > 2 })`, true},
+		{`all([1, 2, 3], fn(x) { x > 1 })`, false},
+		{`!any([], fn(x) { true })`, true},
+		{`let f = fn() { map([1], fn(x) { return 5; 6 }) }; f()`, []int{5}},
+		{`let cmp = fn(a, b) { try { map([1], fn(x) { throw "stale" }) } catch (e) {}; "notint" };
+		let m = ""; try { sort([2, 1], cmp) } catch (e) { m = e.message };
+		m == "comparator must return BOOLEAN or INTEGER, got STRING"`, true},
+		{`map([1, 2], fn(x) { throw "bad" })`, "bad"},
+		{`let r = 0; try { map([1, 2], fn(x) { throw "bad" }) } catch (e) { r = 1 }; r`, 1},
+		{`keys({2: "b", 1: "a", 3: "c"})`, []int{2, 1, 3}},
+		{`values({"b": 1, "a": 2, "c": 3})`, []int{1, 2, 3}},
+		{`entries({"b": 1, "a": 2})[1][1]`, 2},
+		{`has({"a": 1}, "a")`, true},
+		{`has({"a": 1}, [])`, "unusable as hash key: ARRAY"},
+		{`let h = {"a": 1, "b": 2}; let d = delete(h, "a"); [len(keys(h)), len(keys(d))]`, []int{2, 1}},
+		{`let h = {"a": 1, "b": 2}; let v = remove(h, "a"); [v, len(keys(h))]`, []int{1, 1}},
+		{`remove({"a": 1}, "b")`, nil},
+		{`values(merge({"a": 1, "b": 2}, {"c": 3, "a": 4}))`, []int{4, 2, 3}},
+		{`merge({"a": 1}, [])`, "argument to `merge` must be HASH, got ARRAY"},
 	}
 
 	for _, tt := range tests {
@@ -325,6 +582,8 @@ func TestBuiltinFunctions(t *testing.T) {
 				t.Errorf("wrong error message. expected=%q, got=%q",
 					expected, errObj.Message)
 			}
+		case bool:
+			testBooleanObject(t, evaluated, expected)
 		case nil:
 			testNullObject(t, evaluated)
 		case []int:
@@ -365,6 +624,68 @@ func TestArrayLiterals(t *testing.T) {
 	testIntegerObject(t, result.Elements[2], 6)
 }
 
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`len("äöü")`, 3},
+		{`join(split("a,b,,c", ","), "|")`, "a|b||c"},
+		{`trim("  a b  ")`, "a b"},
+		{`upper("abc")`, "ABC"},
+		{`lower("ABC")`, "abc"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`contains("monkey", "key")`, true},
+		{`starts_with("monkey", "mon")`, true},
+		{`ends_with("monkey", "mon")`, false},
+		{`index_of("äöü", "ü")`, 2},
+		{`substr("monkey", 1, 3)`, "onk"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", 4611686018427387903)`,
+			&object.Error{Message: "result of `repeat` too large: 4611686018427387903 times 2 bytes"}},
+		{`replace(repeat("a", 100000), "a", repeat("b", 100000))`,
+			&object.Error{Message: "result of `replace` too large: 10000000000 bytes"}},
+		{`join(chars("äb"), "-")`, "ä-b"},
+		{`"abc"[1]`, "b"},
+		{`"äöü"[2]`, "ü"},
+		{`"abc"[3]`, nil},
+		{`upper(1)`, &object.Error{Message: "argument to `upper` must be STRING, got INTEGER"}},
+		{`"abc"["a"]`, &object.Error{Message: "string index must be INTEGER, got STRING"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("%q: object is not String. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("%q: wrong string. want=%q, got=%q", tt.input, expected, str.Value)
+			}
+		case *object.Error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected.Message {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected.Message, errObj.Message)
+			}
+		}
+	}
+}
+
 func TestArrayIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -419,12 +740,17 @@ func TestHashLiterals(t *testing.T) {
 		FALSE.HashKey():                            6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
+	}
+
+	pairs := map[object.HashKey]object.HashPair{}
+	for _, pair := range result.Pairs() {
+		pairs[pair.Key.(object.Hashable).HashKey()] = pair
 	}
 
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		pair, ok := pairs[expectedKey]
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
 		}
@@ -458,6 +784,168 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestModules(t *testing.T) {
+	dir := t.TempDir()
+	modules := map[string]string{
+		"lib/math.monkey": `let square = fn(x) { x * x };
+export let pi = 3;
+export let area = fn(r) { pi * square(r) };`,
+		"counter.monkey": `export let state = {"n": 0};`,
+		"bump.monkey": `import "counter.monkey" as c;
+export let bump = fn() { c.state["n"] = c.state["n"] + 1; };`,
+		"outer.monkey":   `export let x = y;`,
+		"cycle_a.monkey": `import "cycle_b.monkey" as b;`,
+		"cycle_b.monkey": `import "cycle_a.monkey" as a;`,
+		"returns.monkey": "export let a = 1;\nreturn 5;\nexport let b = 2;",
+	}
+	for name, contents := range modules {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cycleA, cycleB := filepath.Join(dir, "cycle_a.monkey"), filepath.Join(dir, "cycle_b.monkey")
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`import "lib/math.monkey" as m; m.area(2)`, 12},
+		{`import "math.monkey" as m; m["pi"]`, 3},
+		{`import "lib/math.monkey" as m; m.square`, nil},
+		{`import "counter.monkey" as c; import "bump.monkey" as b;
+		b.bump(); b.bump(); c.state["n"]`, 2},
+		{`let f = fn() { import "lib/math.monkey" as m; m.pi }; f() + f()`, 6},
+		{`import "missing.monkey" as m;`, `cannot find module "missing.monkey"`},
+		{`let y = 1; import "outer.monkey" as o;`, "identifier not found: y"},
+		{`import "cycle_a.monkey" as a;`,
+			"import cycle: " + cycleA + " -> " + cycleB + " -> " + cycleA},
+		{`import "returns.monkey" as r; puts(r);`,
+			"returns.monkey:2:1: return outside of function in module"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(
+			lexer.NewWithFilename(tt.input, filepath.Join(dir, "main.monkey"))).ParseProgram()
+		env := object.NewEnvironment()
+		env.Modules().SearchPath = []string{filepath.Join(dir, "lib")}
+		evaluated := Eval(program, env)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if !strings.Contains(errObj.Message, expected) {
+				t.Errorf("%q: wrong error message. expected=%q, got=%q",
+					tt.input, expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-expired.Done()
+
+	tests := []struct {
+		input    string
+		limits   limit.Limits
+		ctx      context.Context
+		expected error
+	}{
+		{"while (true) {}", limit.Limits{MaxInstructions: 10000}, nil, limit.ErrInstructions},
+		{"let a = []; while (true) { a = push(a, 1) }",
+			limit.Limits{MaxAllocations: 10000}, nil, limit.ErrAllocations},
+		{`let s = ""; while (true) { s = s + "monkey" }`,
+			limit.Limits{MaxAllocations: 10000}, nil, limit.ErrAllocations},
+		{`repeat("abcdefgh", 100000000)`,
+			limit.Limits{MaxAllocations: 100000}, nil, limit.ErrAllocations},
+		{`replace(repeat("a", 10000), "a", repeat("b", 10000))`,
+			limit.Limits{MaxAllocations: 100000}, nil, limit.ErrAllocations},
+		{`let s = repeat("a", 8000); try { join([s, s], "") } catch (e) {}; 1`,
+			limit.Limits{MaxAllocations: 1500}, nil, limit.ErrAllocations},
+		{`split(repeat(",", 50000), ",")`,
+			limit.Limits{MaxAllocations: 100000}, nil, limit.ErrAllocations},
+		{`let s = repeat("a", 8000); len(s)`,
+			limit.Limits{MaxAllocations: 1100}, nil, nil},
+		{"while (true) {}", limit.Limits{}, expired, context.DeadlineExceeded},
+		{"try { while (true) {} } catch (e) {}; 1",
+			limit.Limits{MaxInstructions: 10000}, nil, limit.ErrInstructions},
+		{"let f = fn() { try { while (true) {} } finally { return 1 } }; f()",
+			limit.Limits{MaxInstructions: 10000}, nil, limit.ErrInstructions},
+		{`let f = fn(x) { while (true) {} };
+		try { map([1], f) } catch (e) {}; 1`,
+			limit.Limits{MaxInstructions: 10000}, nil, limit.ErrInstructions},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } }; f(100)",
+			limit.Limits{MaxInstructions: 100000, MaxAllocations: 10000}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		ctx := tt.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		env := object.NewEnvironment()
+		evaluated := EvalContext(ctx, program, env, tt.limits)
+
+		errObj, ok := evaluated.(*object.Error)
+		if tt.expected == nil {
+			if ok {
+				t.Errorf("%q: eval error: %s", tt.input, errObj.Message)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%q: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if !errors.Is(errObj.Stop, tt.expected) || !limit.IsStop(errObj.Stop) {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, errObj.Stop)
+		}
+		if env.Budget() != nil {
+			t.Errorf("%q: budget not reset", tt.input)
+		}
+	}
+}
+
+func TestBuiltinIO(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`puts("hello", 1, [2, 3])`, "hello\n1\n[2, 3]\n"},
+		{`let f = fn(x) { puts(x); x }; map([1, 2], f)`, "1\n2\n"},
+		{`puts()`, ""},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		var stdout bytes.Buffer
+		env := object.NewEnvironment()
+		env.SetIO(object.IO{Stdout: &stdout, Stderr: &stdout, Stdin: strings.NewReader("")})
+		evaluated := Eval(program, env)
+		if isError(evaluated) {
+			t.Fatalf("%q: eval error: %s", tt.input, evaluated.Inspect())
+		}
+
+		if stdout.String() != tt.expected {
+			t.Errorf("%q: wrong output. want=%q, got=%q", tt.input, tt.expected, stdout.String())
+		}
+	}
+}
+
 func testEval(input string) object.Object {
 	lexer := lexer.New(input)
 	parser := parser.New(lexer)
@@ -481,6 +969,20 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not a Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -0,0 +1,140 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around changes.
+const contextLines = 3
+
+// Diff returns a unified diff of the lines of old and new, nil if they are
+// equal.
+func Diff(oldName, newName string, old, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	a, b := splitLines(old), splitLines(new)
+	edits := lineEdits(a, b)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// a hunk spans the changes with less than two contexts between them
+		start := i - contextLines
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(edits) {
+			if edits[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].kind == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*contextLines {
+				break
+			}
+			end = next
+		}
+		end += contextLines
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		hunk := edits[start:end]
+		oldStart, newStart := hunk[0].oldLine, hunk[0].newLine
+		var oldCount, newCount int
+		for _, e := range hunk {
+			if e.kind != '+' {
+				oldCount++
+			}
+			if e.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, e := range hunk {
+			out.WriteByte(e.kind)
+			out.WriteString(e.text)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+	return out.Bytes()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		// empty ranges name the line before them
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+type edit struct {
+	kind byte // ' ', '-' or '+'
+	text string
+	// 1-based lines the edit is at in old and new
+	oldLine, newLine int
+}
+
+// lineEdits returns the edits turning a into b along their longest common
+// subsequence.
+func lineEdits(a, b []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i + 1, j + 1})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', a[i], i + 1, j + 1})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i + 1, j + 1})
+			j++
+		}
+	}
+	return edits
+}
+
+func splitLines(src []byte) []string {
+	if len(src) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+}
@@ -0,0 +1,465 @@
+// Package format prints Monkey programs in their canonical layout. Comments
+// are kept, the layout of the source only decides whether blocks and
+// literals span multiple lines and where single blank lines separate
+// statements.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/token"
+	"strings"
+)
+
+const indentation = "    "
+
+// Source formats src, the contents of filename.
+func Source(src []byte, filename string) ([]byte, error) {
+	p := parser.New(lexer.NewWithMode(string(src), filename, lexer.ScanComments))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		errs := make([]error, len(p.Errors()))
+		for i, msg := range p.Errors() {
+			errs[i] = errors.New(msg)
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	var out bytes.Buffer
+	err := Fprint(&out, program)
+	return out.Bytes(), err
+}
+
+// Fprint writes program formatted to w. Its comments are placed by their
+// positions, programs built by hand have to leave them empty.
+func Fprint(w io.Writer, program *ast.Program) error {
+	p := &printer{comments: program.Comments}
+	p.statements(program.Statements, false)
+	p.flushComments(token.Position{Line: int(^uint(0) >> 1)})
+
+	out := bytes.TrimLeft(p.out.Bytes(), "\n")
+	if len(out) > 0 {
+		out = append(out, '\n')
+	}
+	_, err := w.Write(out)
+	return err
+}
+
+// Precedences of the operators, as in the parser.
+const (
+	_ int = iota
+	lowest
+	assign
+	equals
+	lessGreater
+	sum
+	product
+	prefix
+	call
+	index
+	primary
+)
+
+var precedences = map[string]int{
+	"==": equals,
+	"!=": equals,
+	"<":  lessGreater,
+	">":  lessGreater,
+	"<=": lessGreater,
+	">=": lessGreater,
+	"+":  sum,
+	"-":  sum,
+	"*":  product,
+	"/":  product,
+}
+
+type printer struct {
+	out    bytes.Buffer
+	indent int
+	// comments not printed yet, in source order
+	comments []*ast.Comment
+	// last source line printed
+	line int
+}
+
+func (p *printer) write(s string) {
+	p.out.WriteString(s)
+}
+
+// newline starts a new line at the current indentation.
+func (p *printer) newline() {
+	p.write("\n")
+	p.write(strings.Repeat(indentation, p.indent))
+}
+
+// seen records that the source up to pos is printed.
+func (p *printer) seen(pos token.Position) {
+	if pos.Line > p.line {
+		p.line = pos.Line
+	}
+}
+
+// startLine starts the line of the next statement or element starting at
+// pos, after a blank line if there is one in the source.
+func (p *printer) startLine(pos token.Position) {
+	p.flushComments(pos)
+	if p.line > 0 && pos.Line > p.line+1 {
+		p.write("\n")
+	}
+	p.newline()
+	p.seen(pos)
+}
+
+// flushComments prints the comments before pos. Trailing comments stay at
+// the end of the line printed last.
+func (p *printer) flushComments(pos token.Position) {
+	for len(p.comments) > 0 && before(p.comments[0].Pos(), pos) {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+
+		text := strings.TrimRight(comment.Token.Literal, " \t\r")
+		if comment.Trailing && p.out.Len() > 0 {
+			p.write(" " + text)
+		} else {
+			if p.line > 0 && comment.Pos().Line > p.line+1 {
+				p.write("\n")
+			}
+			p.newline()
+			p.write(text)
+		}
+		end := comment.Pos()
+		end.Line += strings.Count(text, "\n")
+		p.seen(end)
+	}
+}
+
+// hasComments reports whether there are comments between from and to.
+func (p *printer) hasComments(from, to token.Position) bool {
+	return len(p.comments) > 0 && before(p.comments[0].Pos(), to) &&
+		!before(p.comments[0].Pos(), from)
+}
+
+func before(a, b token.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+// statements prints stmts, the last of which is the value of the block if
+// valued is set.
+func (p *printer) statements(stmts []ast.Statement, valued bool) {
+	for i, stmt := range stmts {
+		p.startLine(start(stmt))
+		p.statement(stmt)
+
+		var next ast.Statement
+		if i+1 < len(stmts) {
+			next = stmts[i+1]
+		}
+		if needsSemicolon(stmt, next, valued) {
+			p.write(";")
+		}
+	}
+}
+
+// needsSemicolon reports whether stmt, followed by next, ends with a
+// semicolon. Expression statements giving the value of a block don't,
+// neither do if expressions unless the next statement would continue them.
+func needsSemicolon(stmt, next ast.Statement, valued bool) bool {
+	switch stmt := stmt.(type) {
+	case *ast.WhileStatement, *ast.ForStatement, *ast.TryStatement:
+		return false
+	case *ast.ExpressionStatement:
+		if next == nil && valued {
+			return false
+		}
+		if _, ok := stmt.Expression.(*ast.IfExpression); !ok {
+			return true
+		}
+		next, ok := next.(*ast.ExpressionStatement)
+		if !ok {
+			return false
+		}
+		switch next.Token.Type {
+		case token.LPAREN, token.LBRACKET, token.MINUS:
+			return true
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *printer) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		p.write("let " + stmt.Name.Value + " = ")
+		p.expression(stmt.Value, lowest)
+
+	case *ast.ReturnStatement:
+		p.write("return ")
+		p.expression(stmt.ReturnValue, lowest)
+
+	case *ast.ExpressionStatement:
+		p.expression(stmt.Expression, lowest)
+
+	case *ast.WhileStatement:
+		p.write("while (")
+		p.expression(stmt.Condition, lowest)
+		p.write(") ")
+		p.block(stmt.Body, false)
+
+	case *ast.ForStatement:
+		p.write("for (" + stmt.Variable.Value + " in ")
+		p.expression(stmt.Iterable, lowest)
+		p.write(") ")
+		p.block(stmt.Body, false)
+
+	case *ast.BreakStatement:
+		p.write("break")
+
+	case *ast.ContinueStatement:
+		p.write("continue")
+
+	case *ast.TryStatement:
+		p.write("try ")
+		p.block(stmt.Block, false)
+		if stmt.Catch != nil {
+			p.write(" catch (" + stmt.CatchParameter.Value + ") ")
+			p.block(stmt.Catch, false)
+		}
+		if stmt.Finally != nil {
+			p.write(" finally ")
+			p.block(stmt.Finally, false)
+		}
+
+	case *ast.ThrowStatement:
+		p.write("throw ")
+		p.expression(stmt.Value, lowest)
+
+	case *ast.ImportStatement:
+		p.write("import ")
+		p.expression(stmt.Path, lowest)
+		p.write(" as " + stmt.Alias.Value)
+
+	case *ast.ExportStatement:
+		p.write("export ")
+		p.statement(stmt.Statement)
+	}
+}
+
+// block prints a block on a single line if it is short and on a single
+// line in the source. Function bodies and branches of if expressions are
+// valued, their last expression statement gives their value.
+func (p *printer) block(block *ast.BlockStatement, valued bool) {
+	p.seen(block.Pos())
+	singleLine := block.Pos().Line == block.End.Line && len(block.Statements) <= 1 &&
+		!p.hasComments(block.Pos(), block.End)
+
+	switch {
+	case singleLine && len(block.Statements) == 0:
+		p.write("{}")
+	case singleLine:
+		p.write("{ ")
+		p.statement(block.Statements[0])
+		if needsSemicolon(block.Statements[0], nil, valued) {
+			p.write(";")
+		}
+		p.write(" }")
+	default:
+		p.write("{")
+		p.indent++
+		p.statements(block.Statements, valued)
+		p.flushComments(block.End)
+		p.indent--
+		p.newline()
+		p.write("}")
+	}
+	p.seen(block.End)
+}
+
+func (p *printer) expression(expr ast.Expression, precedence int) {
+	if precedenceOf(expr) < precedence {
+		p.write("(")
+		defer p.write(")")
+	}
+
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		p.write(expr.Value)
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.Boolean:
+		p.write(expr.TokenLiteral())
+	case *ast.StringLiteral:
+		p.write(`"` + expr.Value + `"`)
+		end := expr.Pos()
+		end.Line += strings.Count(expr.Value, "\n")
+		p.seen(end)
+
+	case *ast.PrefixExpression:
+		p.write(expr.Operator)
+		// --a would read like a decrement
+		if right, ok := expr.Right.(*ast.PrefixExpression); ok &&
+			expr.Operator == "-" && right.Operator == "-" {
+			p.write("(")
+			p.expression(expr.Right, lowest)
+			p.write(")")
+			break
+		}
+		p.expression(expr.Right, prefix)
+
+	case *ast.InfixExpression:
+		operator := precedences[expr.Operator]
+		p.expression(expr.Left, operator)
+		p.write(" " + expr.Operator + " ")
+		p.expression(expr.Right, operator+1)
+
+	case *ast.AssignExpression:
+		p.expression(expr.Target, call)
+		p.write(" " + expr.Operator + " ")
+		p.expression(expr.Value, assign)
+
+	case *ast.IfExpression:
+		p.write("if (")
+		p.expression(expr.Condition, lowest)
+		p.write(") ")
+		p.block(expr.Consequence, true)
+		if expr.Alternative != nil {
+			p.write(" else ")
+			p.block(expr.Alternative, true)
+		}
+
+	case *ast.FunctionLiteral:
+		names := make([]string, len(expr.Parameters))
+		for i, param := range expr.Parameters {
+			names[i] = param.Value
+		}
+		p.write("fn(" + strings.Join(names, ", ") + ") ")
+		p.block(expr.Body, true)
+
+	case *ast.CallExpression:
+		p.expression(expr.Function, call)
+		multiLine := len(expr.Arguments) > 0 &&
+			start(expr.Arguments[0]).Line > expr.Pos().Line
+		p.list("(", ")", expr.Arguments, expr.End, multiLine)
+
+	case *ast.ArrayLiteral:
+		multiLine := len(expr.Elements) > 0 &&
+			start(expr.Elements[0]).Line > expr.Pos().Line
+		p.list("[", "]", expr.Elements, expr.End, multiLine)
+
+	case *ast.HashLiteral:
+		p.hash(expr)
+
+	case *ast.IndexExpression:
+		p.expression(expr.Left, call)
+		p.write("[")
+		p.expression(expr.Index, lowest)
+		p.write("]")
+
+	case *ast.MemberExpression:
+		p.expression(expr.Object, call)
+		p.write("." + expr.Property.Value)
+	}
+}
+
+// list prints elements between open and close, one per line with trailing
+// commas if multiLine is set. end is the position of close.
+func (p *printer) list(open, close string, elements []ast.Expression, end token.Position, multiLine bool) {
+	p.write(open)
+	if !multiLine {
+		for i, element := range elements {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(element, lowest)
+		}
+		p.write(close)
+		p.seen(end)
+		return
+	}
+
+	p.indent++
+	for _, element := range elements {
+		p.startLine(start(element))
+		p.expression(element, lowest)
+		p.write(",")
+	}
+	p.flushComments(end)
+	p.indent--
+	p.newline()
+	p.write(close)
+	p.seen(end)
+}
+
+func (p *printer) hash(hash *ast.HashLiteral) {
+	multiLine := len(hash.Keys) > 0 && start(hash.Keys[0]).Line > hash.Pos().Line
+	if !multiLine {
+		p.write("{")
+		for i, key := range hash.Keys {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(key, lowest)
+			p.write(": ")
+			p.expression(hash.Pairs[key], lowest)
+		}
+		p.write("}")
+		p.seen(hash.End)
+		return
+	}
+
+	p.write("{")
+	p.indent++
+	for _, key := range hash.Keys {
+		p.startLine(start(key))
+		p.expression(key, lowest)
+		p.write(": ")
+		p.expression(hash.Pairs[key], lowest)
+		p.write(",")
+	}
+	p.flushComments(hash.End)
+	p.indent--
+	p.newline()
+	p.write("}")
+	p.seen(hash.End)
+}
+
+func precedenceOf(expr ast.Expression) int {
+	switch expr := expr.(type) {
+	case *ast.InfixExpression:
+		return precedences[expr.Operator]
+	case *ast.AssignExpression:
+		return assign
+	case *ast.PrefixExpression:
+		return prefix
+	case *ast.CallExpression:
+		return call
+	case *ast.IndexExpression, *ast.MemberExpression:
+		return index
+	default:
+		return primary
+	}
+}
+
+// start returns the position of the first token of node, the position of
+// operators is that of their operator token.
+func start(node ast.Node) token.Position {
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		return start(node.Expression)
+	case *ast.InfixExpression:
+		return start(node.Left)
+	case *ast.AssignExpression:
+		return start(node.Target)
+	case *ast.CallExpression:
+		return start(node.Function)
+	case *ast.IndexExpression:
+		return start(node.Left)
+	case *ast.MemberExpression:
+		return start(node.Object)
+	default:
+		return node.Pos()
+	}
+}
@@ -0,0 +1,201 @@
+package format
+
+import (
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1", "let x = 1;\n"},
+		{"let add = fn(a,b){a+b}", "let add = fn(a, b) { a + b };\n"},
+		{"let f = fn() {\nreturn 1\n}", "let f = fn() {\n    return 1;\n};\n"},
+		{"fn() {}", "fn() {};\n"},
+		{"(1 + 2) * 3; 1 + (2 * 3); 1 - (2 - 3); (1 - 2) - 3", "(1 + 2) * 3;\n1 + 2 * 3;\n1 - (2 - 3);\n1 - 2 - 3;\n"},
+		{"-(1 + 2); !(a == b); -a[0]; (-a)[0]", "-(1 + 2);\n!(a == b);\n-a[0];\n(-a)[0];\n"},
+		{"-(-a); - -1; !!a; -(!a)", "-(-a);\n-(-1);\n!!a;\n-!a;\n"},
+		{"a = b = 1; (a = 1) + 2; a[0] += 1", "a = b = 1;\n(a = 1) + 2;\na[0] += 1;\n"},
+		{"fn(x){x}(1); h.a.b; (h.f)(1)", "fn(x) { x }(1);\nh.a.b;\nh.f(1);\n"},
+		{"let h = {\"a\" : 1,\"b\":[1,2]}", "let h = {\"a\": 1, \"b\": [1, 2]};\n"},
+		{"let a = [\n1,\n2]", "let a = [\n    1,\n    2,\n];\n"},
+		{"let h = {\n\"a\": 1\n}", "let h = {\n    \"a\": 1,\n};\n"},
+		{"f(\n1, 2)", "f(\n    1,\n    2,\n);\n"},
+		{"if (a) { 1 } else { 2 }\nputs(a)", "if (a) { 1 } else { 2 }\nputs(a);\n"},
+		{"if (a) { 1 };\n-1", "if (a) { 1 };\n-1;\n"},
+		{"if (a) {\nb; c\n}", "if (a) {\n    b;\n    c\n}\n"},
+		{"while (i < 3) { i += 1; }", "while (i < 3) { i += 1; }\n"},
+		{"for (x in xs) { if (x) { break; } continue; }",
+			"for (x in xs) {\n    if (x) { break; }\n    continue;\n}\n"},
+		{"try { throw \"x\" } catch (e) { puts(e) } finally { 1 }",
+			"try { throw \"x\"; } catch (e) { puts(e); } finally { 1; }\n"},
+		{"import \"lib\" as lib\nexport let x = lib.y", "import \"lib\" as lib;\nexport let x = lib.y;\n"},
+		{"1.50; 1e9; true", "1.50;\n1e9;\ntrue;\n"},
+		{"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+		{"f(1,\n2);\nlet a = [1,\n2];\nlet h = {\"a\": 1,\n\"b\": 2};\nlet c = 1;",
+			"f(1, 2);\nlet a = [1, 2];\nlet h = {\"a\": 1, \"b\": 2};\nlet c = 1;\n"},
+		{"let s = \"a\nb\";\n\nlet c = 1;", "let s = \"a\nb\";\n\nlet c = 1;\n"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		output, err := Source([]byte(tt.input), "test.monkey")
+		if err != nil {
+			t.Errorf("%q: formatting failed: %s", tt.input, err)
+			continue
+		}
+		if string(output) != tt.expected {
+			t.Errorf("%q: wrong output.\nwant=%q\ngot= %q", tt.input, tt.expected, output)
+		}
+		checkFormatted(t, tt.input, output)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// header
+
+let add = fn(a, b) { // adds
+  // the sum
+  a + b   // result
+};
+
+
+// before x
+let x = [
+  1, // one
+  2,
+  // no three
+];
+let y = {}; // empty
+// end`
+
+	expected := `// header
+
+let add = fn(a, b) { // adds
+    // the sum
+    a + b // result
+};
+
+// before x
+let x = [
+    1, // one
+    2,
+    // no three
+];
+let y = {}; // empty
+// end
+`
+
+	output, err := Source([]byte(input), "test.monkey")
+	if err != nil {
+		t.Fatalf("formatting failed: %s", err)
+	}
+	if string(output) != expected {
+		t.Fatalf("wrong output.\nwant=%q\ngot= %q", expected, output)
+	}
+	checkFormatted(t, input, output)
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source([]byte("let = 1;\nlet x 2;"), "test.monkey")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, expected := range []string{"test.monkey:1:5", "test.monkey:2:7"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("error does not contain %q. got=%q", expected, err)
+		}
+	}
+}
+
+// checkFormatted checks that formatting output again keeps it and that it
+// parses to the same program as input.
+func checkFormatted(t *testing.T, input string, output []byte) {
+	t.Helper()
+
+	again, err := Source(output, "test.monkey")
+	if err != nil {
+		t.Errorf("%q: formatting the output failed: %s", input, err)
+		return
+	}
+	if string(again) != string(output) {
+		t.Errorf("%q: formatting is not idempotent.\nfirst= %q\nsecond=%q", input, output, again)
+	}
+
+	if parse(t, string(output)) != parse(t, input) {
+		t.Errorf("%q: formatting changed the program. got=%q", input, output)
+	}
+}
+
+func parse(t *testing.T, input string) string {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	return program.String()
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		old, new string
+		expected string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\nb\nc\n", "a\nB\nc\n", "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"", "a\n", "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+y\n"},
+		{"1\n2\n3\n4\n", "1\n3\n4\n",
+			"--- old\n+++ new\n@@ -1,4 +1,3 @@\n 1\n-2\n 3\n 4\n"},
+	}
+
+	for _, tt := range tests {
+		diff := Diff("old", "new", []byte(tt.old), []byte(tt.new))
+		if string(diff) != tt.expected {
+			t.Errorf("Diff(%q, %q) wrong.\nwant=%q\ngot= %q", tt.old, tt.new, tt.expected, diff)
+		}
+	}
+}
+
+func TestBlockComments(t *testing.T) {
+	input := `/*
+ * header /* nested */
+ */
+let x = 1; /* trailing */
+let f = fn() {
+  /* inside */
+  x
+};
+let y = 2;`
+
+	expected := `/*
+ * header /* nested */
+ */
+let x = 1; /* trailing */
+let f = fn() {
+    /* inside */
+    x
+};
+let y = 2;
+`
+
+	output, err := Source([]byte(input), "test.monkey")
+	if err != nil {
+		t.Fatalf("formatting failed: %s", err)
+	}
+	if string(output) != expected {
+		t.Fatalf("wrong output.\nwant=%q\ngot= %q", expected, output)
+	}
+	checkFormatted(t, input, output)
+
+	_, err = Source([]byte("let x = 1; /* a"), "test.monkey")
+	if err == nil || !strings.Contains(err.Error(), "unterminated block comment") {
+		t.Errorf("expected an unterminated comment error. got=%v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/module"
+	"monkey/object"
+	"monkey/vm"
+)
+
+// Engines running the programs.
+const (
+	engineVM   = "vm"
+	engineEval = "eval"
+)
+
+// interpreter runs programs with one of the engines. Globals defined by a
+// program stay visible to the programs run afterwards.
+type interpreter interface {
+	// Run runs program and returns the value of its last expression
+	// statement. Programs which don't compile fail with a *compileError.
+	Run(program *ast.Program) (object.Object, error)
+}
+
+type compileError struct {
+	err error
+}
+
+func (ce *compileError) Error() string { return ce.err.Error() }
+func (ce *compileError) Unwrap() error { return ce.err }
+
+// newInterpreter returns an interpreter for engine whose programs see args
+// in the global args.
+func newInterpreter(engine string, streams object.IO, args []string) (interpreter, error) {
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+	argsArray := &object.Array{Elements: elements}
+
+	switch engine {
+	case engineVM:
+		vi := &vmInterpreter{
+			symbolTable: newSymbolTable(),
+			constants:   []object.Object{},
+			globals:     make([]object.Object, vm.GlobalSize),
+			streams:     streams,
+		}
+		vi.globals[argsIndex] = argsArray
+		return vi, nil
+
+	case engineEval:
+		env := object.NewEnvironment()
+		env.SetIO(streams)
+		env.Modules().SearchPath = module.SearchPathFromEnv()
+		env.Set("args", argsArray)
+		return &evalInterpreter{env: env}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown engine %q, want %s or %s", engine, engineVM, engineEval)
+	}
+}
+
+// argsIndex is the index of the global args in the programs compiled by the
+// CLI, bytecode written by `monkey compile` relies on it.
+const argsIndex = 0
+
+// newSymbolTable returns the symbol table programs are compiled with.
+func newSymbolTable() *compiler.SymbolTable {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	symbolTable.Define("args")
+	return symbolTable
+}
+
+type vmInterpreter struct {
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+	streams     object.IO
+}
+
+func (vi *vmInterpreter) Run(program *ast.Program) (object.Object, error) {
+	comp := compiler.NewWithState(vi.symbolTable, vi.constants)
+	comp.SetSearchPath(module.SearchPathFromEnv())
+	comp.SetOptimizationLevel(compiler.OptimizeBasic)
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, &compileError{err}
+	}
+
+	bytecode := comp.ByteCode()
+	vi.constants = bytecode.Constants
+	return vi.RunByteCode(bytecode)
+}
+
+// RunByteCode runs bytecode compiled with a symbol table of newSymbolTable.
+func (vi *vmInterpreter) RunByteCode(bytecode *compiler.ByteCode) (object.Object, error) {
+	machine := vm.NewWithGlobalsStore(bytecode, vi.globals)
+	machine.SetIO(vi.streams)
+	err := machine.Run()
+	if err != nil {
+		return nil, err
+	}
+	return machine.LastPoppedStackElement(), nil
+}
+
+type evalInterpreter struct {
+	env *object.Environment
+}
+
+func (ei *evalInterpreter) Run(program *ast.Program) (object.Object, error) {
+	result := evaluator.Eval(program, ei.env)
+	if err, ok := result.(*object.Error); ok {
+		return nil, evaluator.NewRuntimeError(err)
+	}
+	if result == nil {
+		return evaluator.NULL, nil
+	}
+	return result, nil
+}
@@ -7,18 +7,67 @@ type Lexer struct {
 	position     int
 	readPosition int
 	char         byte
+
+	filename string
+	line     int
+	column   int
+
+	mode Mode
 }
 
+// Mode controls which tokens the lexer reports.
+type Mode uint
+
+const (
+	// ScanComments reports comments as COMMENT tokens instead of skipping
+	// them, for tools like the formatter.
+	ScanComments Mode = 1 << iota
+)
+
 func New(input string) *Lexer {
-	lexer := &Lexer{input: input}
+	return NewWithFilename(input, "")
+}
+
+func NewWithFilename(input string, filename string) *Lexer {
+	return NewWithMode(input, filename, 0)
+}
+
+func NewWithMode(input string, filename string, mode Mode) *Lexer {
+	lexer := &Lexer{input: input, filename: filename, line: 1, mode: mode}
 	lexer.readChar()
+	if lexer.atShebang() && mode&ScanComments == 0 {
+		lexer.readShebang()
+	}
 	return lexer
 }
 
 func (lexer *Lexer) NextToken() token.Token {
 	var tok token.Token
 
-	lexer.skipWhitespace()
+	// scanned like a comment, so that the formatter keeps it
+	if lexer.atShebang() {
+		pos := lexer.currentPosition()
+		return token.Token{Type: token.COMMENT, Literal: lexer.readShebang(), Pos: pos}
+	}
+
+	for {
+		lexer.skipWhitespace()
+		if !lexer.atComment() {
+			break
+		}
+
+		pos := lexer.currentPosition()
+		comment, terminated := lexer.readComment()
+		if !terminated {
+			// the parser reports ILLEGAL tokens starting with "/*"
+			return token.Token{Type: token.ILLEGAL, Literal: comment, Pos: pos}
+		}
+		if lexer.mode&ScanComments != 0 {
+			return token.Token{Type: token.COMMENT, Literal: comment, Pos: pos}
+		}
+	}
+
+	pos := lexer.currentPosition()
 
 	switch lexer.char {
 	case '=':
@@ -29,9 +78,19 @@ func (lexer *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, lexer.char)
 		}
 	case '+':
-		tok = newToken(token.PLUS, lexer.char)
+		if lexer.peekChar() == '=' {
+			lexer.readChar()
+			tok = token.Token{Type: token.PLUS_ASSIGN, Literal: "+="}
+		} else {
+			tok = newToken(token.PLUS, lexer.char)
+		}
 	case '-':
-		tok = newToken(token.MINUS, lexer.char)
+		if lexer.peekChar() == '=' {
+			lexer.readChar()
+			tok = token.Token{Type: token.MINUS_ASSIGN, Literal: "-="}
+		} else {
+			tok = newToken(token.MINUS, lexer.char)
+		}
 	case '!':
 		if lexer.peekChar() == '=' {
 			lexer.readChar()
@@ -40,9 +99,19 @@ func (lexer *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, lexer.char)
 		}
 	case '/':
-		tok = newToken(token.SLASH, lexer.char)
+		if lexer.peekChar() == '=' {
+			lexer.readChar()
+			tok = token.Token{Type: token.SLASH_ASSIGN, Literal: "/="}
+		} else {
+			tok = newToken(token.SLASH, lexer.char)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, lexer.char)
+		if lexer.peekChar() == '=' {
+			lexer.readChar()
+			tok = token.Token{Type: token.ASTERISK_ASSIGN, Literal: "*="}
+		} else {
+			tok = newToken(token.ASTERISK, lexer.char)
+		}
 	case '<':
 		if lexer.peekChar() == '=' {
 			lexer.readChar()
@@ -75,6 +144,8 @@ func (lexer *Lexer) NextToken() token.Token {
 		tok = newToken(token.RBRACKET, lexer.char)
 	case ':':
 		tok = newToken(token.COLON, lexer.char)
+	case '.':
+		tok = newToken(token.DOT, lexer.char)
 	case '"':
 		tok.Type = token.STRING
 		tok.Literal = lexer.readString()
@@ -85,16 +156,18 @@ func (lexer *Lexer) NextToken() token.Token {
 		if isLetter(lexer.char) {
 			tok.Literal = lexer.readIdentifier()
 			tok.Type = token.LookupIdentifier(tok.Literal)
+			tok.Pos = pos
 			return tok
 		} else if isDigit(lexer.char) {
-			tok.Literal = lexer.readNumber()
-			tok.Type = token.INT
+			tok.Literal, tok.Type = lexer.readNumber()
+			tok.Pos = pos
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, lexer.char)
 		}
 	}
 	lexer.readChar()
+	tok.Pos = pos
 	return tok
 }
 
@@ -120,15 +193,82 @@ func (lexer *Lexer) skipWhitespace() {
 	}
 }
 
+func (lexer *Lexer) atComment() bool {
+	return lexer.char == '/' && (lexer.peekChar() == '/' || lexer.peekChar() == '*')
+}
+
+// readComment reads a // comment up to the end of its line or a /* */
+// comment, which may contain further /* */ comments. It reports whether the
+// comment is terminated, unterminated /* */ comments extend to the end of
+// the input.
+func (lexer *Lexer) readComment() (string, bool) {
+	position := lexer.position
+	if lexer.peekChar() == '/' {
+		for lexer.char != '\n' && lexer.char != 0 {
+			lexer.readChar()
+		}
+		return lexer.input[position:lexer.position], true
+	}
+
+	depth := 0
+	for lexer.char != 0 {
+		switch {
+		case lexer.char == '/' && lexer.peekChar() == '*':
+			depth++
+			lexer.readChar()
+		case lexer.char == '*' && lexer.peekChar() == '/':
+			depth--
+			lexer.readChar()
+		}
+		lexer.readChar()
+		if depth == 0 {
+			return lexer.input[position:lexer.position], true
+		}
+	}
+	return lexer.input[position:lexer.position], false
+}
+
+// atShebang reports whether the lexer is at the first line of an executable
+// script, which starts with "#!".
+func (lexer *Lexer) atShebang() bool {
+	return lexer.position == 0 && lexer.char == '#' && lexer.peekChar() == '!'
+}
+
+// readShebang reads the first line of an executable script up to its end.
+func (lexer *Lexer) readShebang() string {
+	position := lexer.position
+	for lexer.char != '\n' && lexer.char != 0 {
+		lexer.readChar()
+	}
+	return lexer.input[position:lexer.position]
+}
+
 func (lexer *Lexer) peekChar() byte {
-	if lexer.readPosition >= len(lexer.input) {
+	return lexer.peekCharAt(1)
+}
+
+func (lexer *Lexer) peekCharAt(distance int) byte {
+	position := lexer.readPosition + distance - 1
+	if position >= len(lexer.input) {
 		return 0
 	} else {
-		return lexer.input[lexer.readPosition]
+		return lexer.input[position]
+	}
+}
+
+func (lexer *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: lexer.filename,
+		Line:     lexer.line,
+		Column:   lexer.column,
 	}
 }
 
 func (lexer *Lexer) readChar() {
+	if lexer.char == '\n' {
+		lexer.line++
+		lexer.column = 0
+	}
 	if lexer.readPosition >= len(lexer.input) {
 		lexer.char = 0
 	} else {
@@ -136,6 +276,7 @@ func (lexer *Lexer) readChar() {
 	}
 	lexer.position = lexer.readPosition
 	lexer.readPosition += 1
+	lexer.column++
 }
 
 func (lexer *Lexer) readIdentifier() string {
@@ -149,12 +290,37 @@ func (lexer *Lexer) readIdentifier() string {
 	return lexer.input[position:lexer.position]
 }
 
-func (lexer *Lexer) readNumber() string {
+func (lexer *Lexer) readNumber() (string, token.TokenType) {
 	position := lexer.position
+	tokenType := token.TokenType(token.INT)
+
+	lexer.readDigits()
+
+	if lexer.char == '.' && isDigit(lexer.peekChar()) {
+		tokenType = token.FLOAT
+		lexer.readChar()
+		lexer.readDigits()
+	}
+
+	if lexer.char == 'e' || lexer.char == 'E' {
+		next := lexer.peekChar()
+		if (next == '+' || next == '-') && isDigit(lexer.peekCharAt(2)) || isDigit(next) {
+			tokenType = token.FLOAT
+			lexer.readChar()
+			if lexer.char == '+' || lexer.char == '-' {
+				lexer.readChar()
+			}
+			lexer.readDigits()
+		}
+	}
+
+	return lexer.input[position:lexer.position], tokenType
+}
+
+func (lexer *Lexer) readDigits() {
 	for isDigit(lexer.char) {
 		lexer.readChar()
 	}
-	return lexer.input[position:lexer.position]
 }
 
 func (lexer *Lexer) readString() string {
@@ -15,7 +15,7 @@ let add = fn(x, y) {
 
 let result = add(five, ten);
 
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -32,6 +32,9 @@ if (5 < 10) {
 "foo bar"
 [1, 2];
 {"foo": "bar"}
+x += 1 -= 2 *= 3 /= 4;
+import "lib.monkey" as lib;
+export let y = lib.x;
 `
 
 	tests := []struct {
@@ -146,6 +149,131 @@ if (5 < 10) {
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
 
+		{token.IDENTIFIER, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.SEMICOLON, ";"},
+
+		{token.IMPORT, "import"},
+		{token.STRING, "lib.monkey"},
+		{token.AS, "as"},
+		{token.IDENTIFIER, "lib"},
+		{token.SEMICOLON, ";"},
+
+		{token.EXPORT, "export"},
+		{token.LET, "let"},
+		{token.IDENTIFIER, "y"},
+		{token.ASSIGN, "="},
+		{token.IDENTIFIER, "lib"},
+		{token.DOT, "."},
+		{token.IDENTIFIER, "x"},
+		{token.SEMICOLON, ";"},
+
+		{token.EOF, ""},
+	}
+
+	lexer := New(input)
+
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x + "ab"
+`
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENTIFIER, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.INT, 1, 9},
+		{token.SEMICOLON, 1, 10},
+		{token.IDENTIFIER, 2, 3},
+		{token.PLUS, 2, 5},
+		{token.STRING, 2, 7},
+		{token.EOF, 3, 1},
+	}
+
+	lexer := NewWithFilename(input, "test.monkey")
+
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos.Filename != "test.monkey" {
+			t.Fatalf("tests[%d] - filename wrong. expected=%q, got=%q", i, "test.monkey", tok.Pos.Filename)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
+
+func TestShebang(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedType token.TokenType
+		expectedLine int
+	}{
+		{"#!/usr/bin/env monkey\nlet", token.LET, 2},
+		{"#!/usr/bin/env monkey", token.EOF, 1},
+		{"let", token.LET, 1},
+		{" #!", token.ILLEGAL, 1},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+		if tok.Type != tt.expectedType || tok.Pos.Line != tt.expectedLine {
+			t.Errorf("%q: wrong token. want=%s on line %d, got=%s on line %d",
+				tt.input, tt.expectedType, tt.expectedLine, tok.Type, tok.Pos.Line)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `3 3.14 0.5 1e9 1e-9 2.5E+3 7.foo 1e x1`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "3"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.INT, "7"},
+		{token.DOT, "."},
+		{token.IDENTIFIER, "foo"},
+		{token.INT, "1"},
+		{token.IDENTIFIER, "e"},
+		{token.IDENTIFIER, "x1"},
 		{token.EOF, ""},
 	}
 
@@ -163,3 +291,83 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 5; // trailing
+// last`
+
+	tests := []struct {
+		mode     Mode
+		expected []token.Token
+	}{
+		{0, []token.Token{
+			{Type: token.LET, Literal: "let"},
+			{Type: token.IDENTIFIER, Literal: "x"},
+			{Type: token.ASSIGN, Literal: "="},
+			{Type: token.INT, Literal: "5"},
+			{Type: token.SEMICOLON, Literal: ";"},
+			{Type: token.EOF, Literal: ""},
+		}},
+		{ScanComments, []token.Token{
+			{Type: token.COMMENT, Literal: "// leading"},
+			{Type: token.LET, Literal: "let"},
+			{Type: token.IDENTIFIER, Literal: "x"},
+			{Type: token.ASSIGN, Literal: "="},
+			{Type: token.INT, Literal: "5"},
+			{Type: token.SEMICOLON, Literal: ";"},
+			{Type: token.COMMENT, Literal: "// trailing"},
+			{Type: token.COMMENT, Literal: "// last"},
+			{Type: token.EOF, Literal: ""},
+		}},
+	}
+
+	for _, tt := range tests {
+		lexer := NewWithMode(input, "", tt.mode)
+
+		for i, expected := range tt.expected {
+			tok := lexer.NextToken()
+			if tok.Type != expected.Type || tok.Literal != expected.Literal {
+				t.Fatalf("mode %d, tests[%d] - wrong token. expected=%s %q, got=%s %q",
+					tt.mode, i, expected.Type, expected.Literal, tok.Type, tok.Literal)
+			}
+		}
+	}
+}
+
+func TestBlockComments(t *testing.T) {
+	tests := []struct {
+		input           string
+		mode            Mode
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedLine    int
+	}{
+		{"/* a */ 1", 0, token.INT, "1", 1},
+		{"/* a\n b */\n1", 0, token.INT, "1", 3},
+		{"/* a /* nested */ still a */ 1", 0, token.INT, "1", 1},
+		{"/**/1", 0, token.INT, "1", 1},
+		{"1 /* a */", ScanComments, token.INT, "1", 1},
+		{"/* a /* b */ c */ 1", ScanComments, token.COMMENT, "/* a /* b */ c */", 1},
+		{"\n/* a\n*/", ScanComments, token.COMMENT, "/* a\n*/", 2},
+		{"/* a /* b */", 0, token.ILLEGAL, "/* a /* b */", 1},
+		{"/*/", ScanComments, token.ILLEGAL, "/*/", 1},
+		{"#!/usr/bin/env monkey\nlet", ScanComments, token.COMMENT, "#!/usr/bin/env monkey", 1},
+		{"1 / 2", 0, token.INT, "1", 1},
+	}
+
+	for _, tt := range tests {
+		tok := NewWithMode(tt.input, "", tt.mode).NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral || tok.Pos.Line != tt.expectedLine {
+			t.Errorf("%q: wrong token. want=%s %q on line %d, got=%s %q on line %d",
+				tt.input, tt.expectedType, tt.expectedLiteral, tt.expectedLine, tok.Type, tok.Literal, tok.Pos.Line)
+		}
+	}
+
+	lexer := New("1 /* a */ + /* b */ 2 // c")
+	for _, expected := range []token.TokenType{token.INT, token.PLUS, token.INT, token.EOF} {
+		if tok := lexer.NextToken(); tok.Type != expected {
+			t.Fatalf("wrong token. want=%s, got=%s", expected, tok.Type)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package limit restricts the resources used by Monkey programs. Both the VM
+// and the evaluator enforce limits with it, so that a program is stopped at
+// the same point in either engine.
+package limit
+
+import (
+	"context"
+	"errors"
+)
+
+// Kinds of limits a program can exceed, a *StopError of one of these kinds
+// matches it with errors.Is. Programs stopped by their context match the
+// error of the context instead.
+var (
+	ErrInstructions = errors.New("instruction limit exceeded")
+	ErrAllocations  = errors.New("allocation limit exceeded")
+)
+
+// Limits restrict the resources of a program, a zero field means no limit.
+type Limits struct {
+	// MaxInstructions is the number of instructions a program may execute.
+	// The evaluator counts every node it evaluates as an instruction.
+	MaxInstructions int64
+	// MaxAllocations is the size of the objects a program may create, in
+	// the units of object.Size.
+	MaxAllocations int64
+}
+
+// StopError is the error of a program stopped because it exceeded its
+// limits or its context is done. Programs can't catch it.
+type StopError struct {
+	Err error
+}
+
+func (se *StopError) Error() string { return se.Err.Error() }
+func (se *StopError) Unwrap() error { return se.Err }
+
+// IsStop reports whether err stops the program.
+func IsStop(err error) bool {
+	var stop *StopError
+	return errors.As(err, &stop)
+}
+
+// checkInterval is the number of instructions between two checks of the
+// context, which are expensive compared to an instruction.
+const checkInterval = 1024
+
+// Budget tracks the resources a program used against its limits. A nil
+// Budget has no limits. It is not safe for concurrent use.
+type Budget struct {
+	ctx    context.Context
+	limits Limits
+
+	instructions int64
+	allocations  int64
+	// instruction count at which Step checks the limits again
+	nextCheck int64
+}
+
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	b := &Budget{ctx: ctx, limits: limits}
+	b.scheduleCheck()
+	return b
+}
+
+// Step counts one instruction. It fails once the program exceeds its
+// instruction limit or its context is done.
+func (b *Budget) Step() error {
+	if b == nil {
+		return nil
+	}
+
+	// kept short to be inlined into the loops of the VM and the evaluator
+	b.instructions++
+	if b.instructions < b.nextCheck {
+		return nil
+	}
+	return b.check()
+}
+
+func (b *Budget) check() error {
+	if b.limits.MaxInstructions > 0 && b.instructions > b.limits.MaxInstructions {
+		return &StopError{Err: ErrInstructions}
+	}
+	if err := b.ctx.Err(); err != nil {
+		return &StopError{Err: err}
+	}
+	b.scheduleCheck()
+	return nil
+}
+
+// scheduleCheck sets the next check to the next multiple of checkInterval
+// or right after the instruction limit, whichever comes first.
+func (b *Budget) scheduleCheck() {
+	b.nextCheck = (b.instructions/checkInterval + 1) * checkInterval
+	if maxInstructions := b.limits.MaxInstructions; maxInstructions > 0 && maxInstructions < b.nextCheck {
+		b.nextCheck = maxInstructions + 1
+	}
+}
+
+// Allocate counts the creation of an object of the given size. It fails
+// once the program exceeds its allocation limit.
+func (b *Budget) Allocate(size int64) error {
+	if b == nil {
+		return nil
+	}
+
+	b.allocations += size
+	if b.limits.MaxAllocations > 0 && b.allocations > b.limits.MaxAllocations {
+		return &StopError{Err: ErrAllocations}
+	}
+	return nil
+}
+
+// Instructions returns the number of instructions counted so far.
+func (b *Budget) Instructions() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.instructions
+}
+
+// Allocations returns the size of the objects counted so far.
+func (b *Budget) Allocations() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.allocations
+}
@@ -0,0 +1,77 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestInstructionLimit(t *testing.T) {
+	b := NewBudget(context.Background(), Limits{MaxInstructions: 3000})
+	for i := 0; i < 3000; i++ {
+		if err := b.Step(); err != nil {
+			t.Fatalf("step %d failed: %s", i+1, err)
+		}
+	}
+
+	err := b.Step()
+	if !errors.Is(err, ErrInstructions) || !IsStop(err) {
+		t.Fatalf("wrong error. want=%q, got=%v", ErrInstructions, err)
+	}
+	if b.Instructions() != 3001 {
+		t.Errorf("wrong instruction count. want=3001, got=%d", b.Instructions())
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewBudget(ctx, Limits{})
+	for i := 0; i < 2*checkInterval; i++ {
+		if err := b.Step(); err != nil {
+			t.Fatalf("step %d failed: %s", i+1, err)
+		}
+	}
+
+	cancel()
+	var err error
+	for i := 0; i < checkInterval && err == nil; i++ {
+		err = b.Step()
+	}
+	if !errors.Is(err, context.Canceled) || !IsStop(err) {
+		t.Fatalf("wrong error. want=%q, got=%v", context.Canceled, err)
+	}
+}
+
+func TestAllocationLimit(t *testing.T) {
+	b := NewBudget(context.Background(), Limits{MaxAllocations: 10})
+	if err := b.Allocate(4); err != nil {
+		t.Fatalf("Allocate failed: %s", err)
+	}
+	if err := b.Allocate(6); err != nil {
+		t.Fatalf("Allocate failed: %s", err)
+	}
+
+	err := b.Allocate(1)
+	if !errors.Is(err, ErrAllocations) || !IsStop(err) {
+		t.Fatalf("wrong error. want=%q, got=%v", ErrAllocations, err)
+	}
+	if b.Allocations() != 11 {
+		t.Errorf("wrong allocation count. want=11, got=%d", b.Allocations())
+	}
+}
+
+func TestNilBudget(t *testing.T) {
+	var b *Budget
+	if err := b.Step(); err != nil {
+		t.Errorf("Step failed: %s", err)
+	}
+	if err := b.Allocate(1 << 40); err != nil {
+		t.Errorf("Allocate failed: %s", err)
+	}
+	if b.Instructions() != 0 || b.Allocations() != 0 {
+		t.Errorf("nil budget counted resources")
+	}
+	if IsStop(errors.New("boom")) {
+		t.Errorf("IsStop reported an ordinary error")
+	}
+}
@@ -1,65 +1,554 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
+	"monkey/ast"
 	"monkey/compiler"
+	"monkey/disasm"
+	"monkey/format"
 	"monkey/lexer"
+	"monkey/module"
+	"monkey/object"
 	"monkey/parser"
 	"monkey/repl"
-	"monkey/vm"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 )
 
+const compiledExtension = ".mkc"
+
+// testSuffix marks the scripts run by `monkey test`.
+const testSuffix = "_test.monkey"
+
+// Exit codes of the CLI.
+const (
+	exitOK = 0
+	// exitFailure reports a failed program or test, or a file which can't
+	// be read or written.
+	exitFailure = 1
+	// exitUsage reports wrong arguments.
+	exitUsage = 2
+	// exitInvalid reports source which does not parse or compile.
+	exitInvalid = 3
+)
+
+const usage = `Usage:
+
+	monkey [run] [-engine vm|eval] file|- [arguments]
+	monkey repl [-engine vm|eval]
+	monkey compile [-o output] file|-
+	monkey disasm file|-
+	monkey fmt [-w] [-d] file...|-
+	monkey check file...
+	monkey test [-engine vm|eval] [file|directory...]
+
+Without arguments monkey starts the REPL. A file named - is read from the
+standard input, files ending in .mkc hold bytecode written by compile.
+`
+
+// cli runs the commands of the monkey binary.
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
 func main() {
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	os.Exit(c.main(os.Args[1:]))
+}
+
+// main runs the command given by args and returns the exit code.
+func (c *cli) main(args []string) int {
+	if len(args) == 0 {
+		return c.repl(nil)
+	}
+
+	switch args[0] {
+	case "run":
+		return c.run(args[1:])
+	case "repl":
+		return c.repl(args[1:])
+	case "compile":
+		return c.compile(args[1:])
+	case "disasm":
+		return c.disasm(args[1:])
+	case "fmt":
+		return c.format(args[1:])
+	case "check":
+		return c.check(args[1:])
+	case "test":
+		return c.test(args[1:])
+	case "help", "-h", "-help", "--help":
+		io.WriteString(c.stdout, usage)
+		return exitOK
+	default:
+		return c.run(args)
+	}
+}
+
+// flagSet returns the flags of the command name, which print their errors
+// to the standard error of the CLI.
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		io.WriteString(c.stderr, usage)
+	}
+	return flags
+}
+
+func (c *cli) streams() object.IO {
+	return object.IO{Stdout: c.stdout, Stderr: c.stderr, Stdin: c.stdin}
+}
+
+func (c *cli) run(args []string) int {
+	flags := c.flagSet("run")
+	engine := flags.String("engine", engineVM, "engine running the script, vm or eval")
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		return c.usageError("run: no script given")
+	}
+	file := flags.Arg(0)
+
+	interp, err := newInterpreter(*engine, c.streams(), flags.Args()[1:])
+	if err != nil {
+		return c.usageError("run: %s", err)
+	}
+
+	if filepath.Ext(file) == compiledExtension {
+		vi, ok := interp.(*vmInterpreter)
+		if !ok {
+			return c.usageError("run: bytecode can only be run by the %s engine", engineVM)
+		}
+		bytecode, code := c.loadByteCode(file)
+		if bytecode == nil {
+			return code
+		}
+		_, err = vi.RunByteCode(bytecode)
+		return c.reportRunError(err)
+	}
+
+	program, code := c.parseFile(file)
+	if program == nil {
+		return code
+	}
+	_, err = interp.Run(program)
+	return c.reportRunError(err)
+}
+
+func (c *cli) repl(args []string) int {
+	flags := c.flagSet("repl")
+	engine := flags.String("engine", engineVM, "engine running the input, vm or eval")
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+	if *engine != engineVM && *engine != engineEval {
+		return c.usageError("repl: unknown engine %q, want %s or %s", *engine, engineVM, engineEval)
+	}
+
 	user, err := user.Current()
 	if err != nil {
-		panic(err)
+		printError(c.stderr, "REPL", err)
+		return exitFailure
+	}
+
+	fmt.Fprintf(c.stdout,
+		"Hello %s! This is the Monkey programming language!\n",
+		user.Username)
+	fmt.Fprint(c.stdout, "Feel free to type in commands\n")
+	repl.StartWithConfig(c.stdin, c.stdout, repl.Config{
+		Engine:      *engine,
+		HistoryFile: historyFile(user),
+	})
+	return exitOK
+}
+
+// historyFile returns the file keeping the REPL history, $MONKEY_HISTORY
+// or .monkey_history in the home directory.
+func historyFile(user *user.User) string {
+	if path, ok := os.LookupEnv("MONKEY_HISTORY"); ok {
+		return path
+	}
+	return filepath.Join(user.HomeDir, ".monkey_history")
+}
+
+func (c *cli) compile(args []string) int {
+	flags := c.flagSet("compile")
+	output := flags.String("o", "", "output file, - for the standard output")
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		return c.usageError("compile: want exactly one file")
+	}
+	file := flags.Arg(0)
+
+	bytecode, code := c.compileFile(file)
+	if bytecode == nil {
+		return code
+	}
+
+	if *output == "" {
+		*output = "-"
+		if file != "-" {
+			*output = strings.TrimSuffix(file, filepath.Ext(file)) + compiledExtension
+		}
+	}
+	if *output == "-" {
+		_, err := bytecode.WriteTo(c.stdout)
+		return c.reportError("Compiler", err)
 	}
 
-	if len(os.Args) == 2 {
-		runScript(os.Args[1])
+	out, err := os.Create(*output)
+	if err != nil {
+		return c.reportError("Compiler", err)
+	}
+	_, err = bytecode.WriteTo(out)
+	if err != nil {
+		out.Close()
+		return c.reportError("Compiler", err)
+	}
+	return c.reportError("Compiler", out.Close())
+}
+
+func (c *cli) disasm(args []string) int {
+	if len(args) != 1 {
+		return c.usageError("disasm: want exactly one file")
+	}
+	file := args[0]
+
+	var bytecode *compiler.ByteCode
+	var code int
+	if filepath.Ext(file) == compiledExtension {
+		bytecode, code = c.loadByteCode(file)
 	} else {
-		fmt.Printf(
-			"Hello %s! This is the Monkey programming language!\n",
-			user.Username)
-		fmt.Print("Feel free to type in commands\n")
-		repl.Start(os.Stdin, os.Stdout)
+		bytecode, code = c.compileFile(file)
 	}
+	if bytecode == nil {
+		return code
+	}
+
+	err := disasm.Disassemble(c.stdout, bytecode)
+	return c.reportError("Disassembler", err)
 }
 
-func runScript(file string) {
+// format prints files formatted, writes them back with -w or prints the
+// differences to the formatted files with -d, failing if there are any.
+func (c *cli) format(args []string) int {
+	flags := c.flagSet("fmt")
+	write := flags.Bool("w", false, "write the formatted source back to the files")
+	diff := flags.Bool("d", false, "print the changes formatting would make")
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		return c.usageError("fmt: no files given")
+	}
+
+	code := exitOK
+	for _, file := range flags.Args() {
+		if *write && file == "-" {
+			return c.usageError("fmt: can't write the standard input back")
+		}
+
+		contents, err := c.readSource(file)
+		if err != nil {
+			printError(c.stderr, "Reader", err)
+			code = maxCode(code, exitFailure)
+			continue
+		}
+
+		lexer := lexer.NewWithMode(contents, sourceName(file), lexer.ScanComments)
+		parser := parser.New(lexer)
+		program := parser.ParseProgram()
+		if len(parser.Errors()) != 0 {
+			printErrors(c.stderr, "Parser", parser.Errors())
+			code = maxCode(code, exitInvalid)
+			continue
+		}
+
+		var out bytes.Buffer
+		format.Fprint(&out, program)
+		formatted := out.Bytes()
+
+		switch {
+		case *diff:
+			changes := format.Diff(sourceName(file)+".orig", sourceName(file), []byte(contents), formatted)
+			if changes != nil {
+				c.stdout.Write(changes)
+				code = maxCode(code, exitFailure)
+			}
+		case *write:
+			if string(formatted) == contents {
+				continue
+			}
+			err := os.WriteFile(file, formatted, 0o644)
+			if err != nil {
+				printError(c.stderr, "Formatter", err)
+				code = maxCode(code, exitFailure)
+			}
+		default:
+			c.stdout.Write(formatted)
+		}
+	}
+	return code
+}
+
+// check parses and compiles files without running them.
+func (c *cli) check(args []string) int {
+	if len(args) == 0 {
+		return c.usageError("check: no files given")
+	}
+
+	code := exitOK
+	for _, file := range args {
+		bytecode, fileCode := c.compileFile(file)
+		if bytecode == nil && fileCode > code {
+			code = fileCode
+		}
+	}
+	return code
+}
+
+// test runs the test scripts in paths, directories are searched for files
+// ending in _test.monkey. Each script runs in an interpreter of its own, the
+// functions it defines on the top level whose names start with test are
+// called afterwards. A test fails if it throws.
+func (c *cli) test(args []string) int {
+	flags := c.flagSet("test")
+	engine := flags.String("engine", engineVM, "engine running the tests, vm or eval")
+	if flags.Parse(args) != nil {
+		return exitUsage
+	}
+	if *engine != engineVM && *engine != engineEval {
+		return c.usageError("test: unknown engine %q, want %s or %s", *engine, engineVM, engineEval)
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := findTests(paths)
+	if err != nil {
+		return c.reportError("Test", err)
+	}
+	if len(files) == 0 {
+		io.WriteString(c.stderr, "test: no test files found\n")
+		return exitFailure
+	}
+
+	code := exitOK
+	passed, failed := 0, 0
+	for _, file := range files {
+		program, fileCode := c.parseFile(file)
+		if program == nil {
+			code = maxCode(code, fileCode)
+			continue
+		}
+		interp, _ := newInterpreter(*engine, c.streams(), nil)
+		_, err := interp.Run(program)
+		if err != nil {
+			fmt.Fprintf(c.stdout, "FAIL %s\n", file)
+			c.reportRunError(err)
+			code = maxCode(code, exitFailure)
+			continue
+		}
+
+		for _, name := range testNames(program) {
+			_, err := interp.Run(callProgram(name))
+			if err != nil {
+				fmt.Fprintf(c.stdout, "FAIL %s %s\n     %s\n", file, name, err)
+				failed++
+				code = maxCode(code, exitFailure)
+			} else {
+				fmt.Fprintf(c.stdout, "ok   %s %s\n", file, name)
+				passed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(c.stdout, "FAIL: %d of %d tests failed\n", failed, passed+failed)
+	} else if code == exitOK {
+		fmt.Fprintf(c.stdout, "PASS: %d tests\n", passed)
+	}
+	return code
+}
+
+func maxCode(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// findTests returns the test scripts among paths and in the directories of
+// paths.
+func findTests(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.HasSuffix(path, testSuffix) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// testNames returns the names of the test functions defined by program.
+func testNames(program *ast.Program) []string {
+	var names []string
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || !strings.HasPrefix(let.Name.Value, "test") {
+			continue
+		}
+		if fn, ok := let.Value.(*ast.FunctionLiteral); ok && len(fn.Parameters) == 0 {
+			names = append(names, let.Name.Value)
+		}
+	}
+	return names
+}
+
+// callProgram returns a program calling the global function name.
+func callProgram(name string) *ast.Program {
+	return parser.New(lexer.New(name + "()")).ParseProgram()
+}
+
+// readSource reads file, or the standard input if file is -.
+func (c *cli) readSource(file string) (string, error) {
+	if file == "-" {
+		contents, err := io.ReadAll(c.stdin)
+		return string(contents), err
+	}
 	contents, err := os.ReadFile(file)
+	return string(contents), err
+}
+
+// sourceName returns the filename of file used in positions and for
+// imports, scripts read from the standard input import relative to the
+// working directory.
+func sourceName(file string) string {
+	if file == "-" {
+		return "<stdin>"
+	}
+	return file
+}
+
+// parseFile parses file or reports why it can't and returns nil and the
+// exit code.
+func (c *cli) parseFile(file string) (*ast.Program, int) {
+	contents, err := c.readSource(file)
 	if err != nil {
-		panic(err)
+		printError(c.stderr, "Reader", err)
+		return nil, exitFailure
 	}
-	lexer := lexer.New(string(contents))
+
+	lexer := lexer.NewWithFilename(contents, sourceName(file))
 	parser := parser.New(lexer)
 	program := parser.ParseProgram()
-
 	if len(parser.Errors()) != 0 {
-		printErrors(os.Stderr, "Parser", parser.Errors())
-		return
+		printErrors(c.stderr, "Parser", parser.Errors())
+		return nil, exitInvalid
+	}
+	return program, exitOK
+}
+
+// compileFile compiles file or reports why it can't and returns nil and the
+// exit code.
+func (c *cli) compileFile(file string) (*compiler.ByteCode, int) {
+	program, code := c.parseFile(file)
+	if program == nil {
+		return nil, code
 	}
 
-	comp := compiler.New()
-	err = comp.Compile(program)
+	comp := compiler.NewWithState(newSymbolTable(), []object.Object{})
+	comp.SetSearchPath(module.SearchPathFromEnv())
+	comp.SetOptimizationLevel(compiler.OptimizeBasic)
+	err := comp.Compile(program)
 	if err != nil {
-		printError(os.Stderr, "Compiler", err)
-		return
+		printError(c.stderr, "Compiler", err)
+		return nil, exitInvalid
 	}
 
-	machine := vm.New(comp.ByteCode())
+	return comp.ByteCode(), exitOK
+}
+
+// loadByteCode reads the bytecode in file or reports why it can't and
+// returns nil and the exit code.
+func (c *cli) loadByteCode(file string) (*compiler.ByteCode, int) {
+	in, err := os.Open(file)
+	if err != nil {
+		printError(c.stderr, "Loader", err)
+		return nil, exitFailure
+	}
+	defer in.Close()
 
-	err = machine.Run()
+	bytecode, err := compiler.ReadByteCode(in)
 	if err != nil {
-		printError(os.Stderr, "VM", err)
-		return
+		printError(c.stderr, "Loader", err)
+		return nil, exitInvalid
 	}
 
-	io.WriteString(os.Stdout, machine.LastPoppedStackElement().Inspect())
-	io.WriteString(os.Stdout, "\n")
+	return bytecode, exitOK
+}
+
+// reportRunError reports err of a program run and returns the exit code.
+func (c *cli) reportRunError(err error) int {
+	var compileErr *compileError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &compileErr):
+		printError(c.stderr, "Compiler", compileErr.err)
+		return exitInvalid
+	default:
+		printError(c.stderr, "Runtime", err)
+		printStackTrace(c.stderr, err)
+		return exitFailure
+	}
+}
+
+// reportError reports err of module, if any, and returns the exit code.
+func (c *cli) reportError(module string, err error) int {
+	if err == nil {
+		return exitOK
+	}
+	printError(c.stderr, module, err)
+	return exitFailure
+}
+
+func (c *cli) usageError(format string, a ...any) int {
+	fmt.Fprintf(c.stderr, format+"\n", a...)
+	io.WriteString(c.stderr, usage)
+	return exitUsage
 }
 
 func printErrors(out io.Writer, module string, errors []string) {
@@ -72,3 +561,12 @@ func printErrors(out io.Writer, module string, errors []string) {
 func printError(out io.Writer, module string, err error) {
 	io.WriteString(out, fmt.Sprintf("🙈 %s error occured: %s\n", module, err))
 }
+
+// printStackTrace prints the stack trace of the runtime errors of both
+// engines.
+func printStackTrace(out io.Writer, err error) {
+	var traced interface{ StackTrace() string }
+	if errors.As(err, &traced) {
+		io.WriteString(out, traced.StackTrace())
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeScripts(t *testing.T, scripts map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range scripts {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(contents), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCLI(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"args.monkey":      "#!/usr/bin/env monkey\nputs(len(args)); puts(args);",
+		"invalid.monkey":   "let = 1;",
+		"undefined.monkey": "x",
+		"fails.monkey":     `puts("before"); throw "boom"; puts("after");`,
+		"result.monkey":    "1 + 2",
+		"messy.monkey":     "let x=1\n",
+		"tests/math_test.monkey": `let add = fn(a, b) { a + b };
+let testAdd = fn() { if (add(1, 2) != 3) { throw "wrong sum" } };
+let testHelperIsNoTest = 1;
+let helper = fn() { throw "not a test" };`,
+		"tests/fail_test.monkey": `let testFails = fn() { throw "boom" };
+let testPasses = fn() { 1 };`,
+		"tests/ignored.monkey": `let testIgnored = fn() { throw "ignored" };`,
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		args           []string
+		stdin          string
+		expectedCode   int
+		expectedStdout string
+		expectedStderr string
+	}{
+		{[]string{path("args.monkey"), "a", "b"}, "", exitOK, "2\n[a, b]\n", ""},
+		{[]string{"run", "-engine", "eval", path("args.monkey"), "-x"}, "", exitOK, "1\n[-x]\n", ""},
+		{[]string{"--engine", "eval", path("args.monkey")}, "", exitOK, "0\n[]\n", ""},
+		{[]string{"-", "a"}, "puts(args[0])", exitOK, "a\n", ""},
+		{[]string{"run", "-engine=eval", "-"}, "puts(1 + 1)", exitOK, "2\n", ""},
+		{[]string{path("result.monkey")}, "", exitOK, "", ""},
+		{[]string{path("invalid.monkey")}, "", exitInvalid, "", "Parser errors"},
+		{[]string{path("undefined.monkey")}, "", exitInvalid, "", "undefined variable x"},
+		{[]string{"run", "-engine", "eval", path("invalid.monkey")}, "", exitInvalid, "", "Parser errors"},
+		{[]string{path("fails.monkey")}, "", exitFailure, "before\n", "boom"},
+		{[]string{"run", "-engine", "eval", path("fails.monkey")}, "", exitFailure, "before\n", "boom"},
+		{[]string{"run", "-engine", "eval", path("fails.monkey")}, "", exitFailure, "before\n",
+			"\tat <main> (" + path("fails.monkey") + ":1)\n"},
+		{[]string{"run", path("fails.monkey")}, "", exitFailure, "before\n",
+			"\tat <main> (" + path("fails.monkey") + ":1)\n"},
+		{[]string{path("missing.monkey")}, "", exitFailure, "", "no such file"},
+		{[]string{"run"}, "", exitUsage, "", "no script given"},
+		{[]string{"run", "-engine", "js", path("args.monkey")}, "", exitUsage, "", `unknown engine "js"`},
+		{[]string{"run", "-nope", path("args.monkey")}, "", exitUsage, "", "flag provided but not defined"},
+		{[]string{"check", path("args.monkey"), path("result.monkey")}, "", exitOK, "", ""},
+		{[]string{"check", path("args.monkey"), path("undefined.monkey")}, "", exitInvalid, "", "undefined variable x"},
+		{[]string{"check", "-"}, "let = 1", exitInvalid, "", "<stdin>:1:5"},
+		{[]string{"check"}, "", exitUsage, "", "no files given"},
+		{[]string{"disasm", "-"}, "1 + 2", exitOK, "OpConstant", ""},
+		{[]string{"fmt", path("messy.monkey")}, "", exitOK, "let x = 1;\n", ""},
+		{[]string{"fmt", "-d", path("messy.monkey")}, "", exitFailure, "-let x=1\n+let x = 1;\n", ""},
+		{[]string{"fmt", "-d", "-"}, "let x = 1;\n", exitOK, "", ""},
+		{[]string{"fmt", "-"}, "let = 1", exitInvalid, "", "Parser errors"},
+		{[]string{"fmt", "-w", "-"}, "", exitUsage, "", "can't write the standard input back"},
+		{[]string{"fmt"}, "", exitUsage, "", "no files given"},
+		{[]string{"disasm", "a", "b"}, "", exitUsage, "", "want exactly one file"},
+		{[]string{"test", path("tests")}, "", exitFailure,
+			"FAIL " + path("tests/fail_test.monkey") + " testFails\n     boom\n" +
+				"ok   " + path("tests/fail_test.monkey") + " testPasses\n" +
+				"ok   " + path("tests/math_test.monkey") + " testAdd\n" +
+				"FAIL: 1 of 3 tests failed\n", ""},
+		{[]string{"test", "-engine", "eval", path("tests/math_test.monkey")}, "", exitOK,
+			"ok   " + path("tests/math_test.monkey") + " testAdd\nPASS: 1 tests\n", ""},
+		{[]string{"test", path("tests/ignored.monkey"), path("invalid.monkey")}, "", exitInvalid,
+			"testIgnored\n     ignored\n", "Parser errors"},
+		{[]string{"test", path("missing")}, "", exitFailure, "", "no such file"},
+		{[]string{"help"}, "", exitOK, "Usage:", ""},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		c := &cli{stdin: strings.NewReader(tt.stdin), stdout: &stdout, stderr: &stderr}
+		code := c.main(tt.args)
+
+		if code != tt.expectedCode {
+			t.Errorf("%q: wrong exit code. want=%d, got=%d (stderr %q)",
+				tt.args, tt.expectedCode, code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), tt.expectedStdout) {
+			t.Errorf("%q: stdout does not contain %q. got=%q", tt.args, tt.expectedStdout, stdout.String())
+		}
+		if tt.expectedStdout == "" && stdout.Len() != 0 && code != exitOK {
+			t.Errorf("%q: unexpected stdout. got=%q", tt.args, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), tt.expectedStderr) {
+			t.Errorf("%q: stderr does not contain %q. got=%q", tt.args, tt.expectedStderr, stderr.String())
+		}
+		if tt.expectedStderr == "" && stderr.Len() != 0 {
+			t.Errorf("%q: unexpected stderr. got=%q", tt.args, stderr.String())
+		}
+	}
+}
+
+func TestExceptionTraces(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"ex.monkey": `let inner = fn() { throw "x" };
+let outer = fn() { inner() + 1 };
+try { outer() } catch (e) { puts(e["trace"]) };`,
+		"nested.monkey": `let fail = fn() { 1 / 0 };
+let rethrow = fn() {
+  try { fail() } catch (e) { puts(e["trace"]); throw e }
+};
+try { [rethrow()] } catch (e) { puts(e["trace"]) };`,
+		"callback.monkey": `import "lib.monkey" as lib;
+try { map([1], fn(x) { lib["check"](x) + 1 }) } catch (e) { puts(e["trace"]) };`,
+		"tail.monkey": `let g = fn(a) { a };
+let f = fn() {
+  g()
+};
+let h = fn() {
+  len(1)
+};
+try { f() } catch (e) { puts(e["trace"]) };
+try { h() } catch (e) { puts(e["trace"]) };`,
+		"lib.monkey": `export let check = fn(x) {
+  x + "s"
+};`,
+	})
+
+	tests := []struct {
+		script   string
+		expected string
+	}{
+		{"ex.monkey",
+			"[inner (ex.monkey:1), outer (ex.monkey:2), <main> (ex.monkey:3)]\n"},
+		{"nested.monkey",
+			"[fail (nested.monkey:1), rethrow (nested.monkey:3), <main> (nested.monkey:5)]\n" +
+				"[fail (nested.monkey:1), rethrow (nested.monkey:3), <main> (nested.monkey:5)]\n"},
+		{"tail.monkey",
+			"[f (tail.monkey:3), <main> (tail.monkey:8)]\n" +
+				"[h (tail.monkey:6), <main> (tail.monkey:9)]\n"},
+		{"callback.monkey",
+			"[check (lib.monkey:2), <anonymous> (callback.monkey:2), <main> (callback.monkey:2)]\n"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{"vm", "eval"} {
+			var stdout, stderr bytes.Buffer
+			c := &cli{stdin: strings.NewReader(""), stdout: &stdout, stderr: &stderr}
+			code := c.main([]string{"run", "-engine", engine, filepath.Join(dir, tt.script)})
+			if code != exitOK {
+				t.Errorf("%s with %s: run failed with %d: %s", tt.script, engine, code, stderr.String())
+				continue
+			}
+			expected := strings.ReplaceAll(tt.expected, "(", "("+dir+string(filepath.Separator))
+			if stdout.String() != expected {
+				t.Errorf("%s with %s: wrong trace. want=%q, got=%q",
+					tt.script, engine, expected, stdout.String())
+			}
+		}
+	}
+}
+
+func TestFormatWrite(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"messy.monkey": "let add=fn(a,b){a+b}\nadd(1,2)",
+	})
+	script := filepath.Join(dir, "messy.monkey")
+
+	var stdout, stderr bytes.Buffer
+	c := &cli{stdin: strings.NewReader(""), stdout: &stdout, stderr: &stderr}
+	if code := c.main([]string{"fmt", "-w", script}); code != exitOK {
+		t.Fatalf("fmt -w failed with %d: %s", code, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("fmt -w printed to stdout. got=%q", stdout.String())
+	}
+
+	contents, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "let add = fn(a, b) { a + b };\nadd(1, 2);\n"
+	if string(contents) != expected {
+		t.Errorf("file not formatted. want=%q, got=%q", expected, contents)
+	}
+
+	if code := c.main([]string{"fmt", "-d", script}); code != exitOK {
+		t.Errorf("formatted file differs. exit code %d, diff %q", code, stdout.String())
+	}
+
+	// executable scripts keep their first line
+	executable := filepath.Join(dir, "executable.monkey")
+	err = os.WriteFile(executable, []byte("#!/usr/bin/env monkey\nputs( 1 )"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := c.main([]string{"fmt", "-w", executable}); code != exitOK {
+		t.Fatalf("fmt -w failed with %d: %s", code, stderr.String())
+	}
+	contents, err = os.ReadFile(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "#!/usr/bin/env monkey\nputs(1);\n"
+	if string(contents) != expected {
+		t.Errorf("shebang not kept. want=%q, got=%q", expected, contents)
+	}
+}
+
+func TestCompiledScripts(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"args.monkey": "puts(args);",
+	})
+	script := filepath.Join(dir, "args.monkey")
+	compiled := filepath.Join(dir, "args.mkc")
+
+	var stdout, stderr bytes.Buffer
+	c := &cli{stdin: strings.NewReader(""), stdout: &stdout, stderr: &stderr}
+	if code := c.main([]string{"compile", script}); code != exitOK {
+		t.Fatalf("compile failed with %d: %s", code, stderr.String())
+	}
+
+	if code := c.main([]string{compiled, "a"}); code != exitOK {
+		t.Fatalf("run failed with %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "[a]\n" {
+		t.Errorf("wrong output. want=%q, got=%q", "[a]\n", stdout.String())
+	}
+
+	if code := c.main([]string{"run", "-engine", "eval", compiled}); code != exitUsage {
+		t.Errorf("wrong exit code running bytecode with eval. want=%d, got=%d", exitUsage, code)
+	}
+
+	stdout.Reset()
+	if code := c.main([]string{"compile", "-o", "-", script}); code != exitOK {
+		t.Fatalf("compile failed with %d: %s", code, stderr.String())
+	}
+	contents, err := os.ReadFile(compiled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stdout.Bytes(), contents) {
+		t.Errorf("bytecode written to stdout differs from the file")
+	}
+}
@@ -0,0 +1,128 @@
+// Package module locates and parses the files imported by Monkey programs.
+// Both the compiler and the evaluator use it, so that imports resolve the
+// same way in either engine.
+package module
+
+import (
+	"errors"
+	"fmt"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SearchPathVariable is the environment variable listing the directories
+// searched for modules which are not found next to the importing file.
+const SearchPathVariable = "MONKEYPATH"
+
+// SearchPathFromEnv returns the directories listed in SearchPathVariable.
+func SearchPathFromEnv() []string {
+	return filepath.SplitList(os.Getenv(SearchPathVariable))
+}
+
+// Resolve returns the absolute path of the module imported as path by the
+// file importer. A relative path is looked up in the directory of the
+// importer first and then in each directory of searchPath. Input without a
+// filename, like the REPL's, imports relative to the working directory.
+func Resolve(path, importer string, searchPath []string) (string, error) {
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		candidates = []string{filepath.Join(filepath.Dir(importer), path)}
+		for _, dir := range searchPath {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		return filepath.Abs(candidate)
+	}
+
+	return "", fmt.Errorf("cannot find module %q", path)
+}
+
+// Parse reads and parses the module at filename.
+func Parse(filename string) (*ast.Program, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.NewWithFilename(string(contents), filename))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		errs := make([]error, len(p.Errors()))
+		for i, msg := range p.Errors() {
+			errs[i] = errors.New(msg)
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	// the value of a module is the hash of its exports, it can't return
+	// another one
+	if ret := topLevelReturn(program); ret != nil {
+		return nil, fmt.Errorf("%s: return outside of function in module", ret.Pos())
+	}
+
+	return program, nil
+}
+
+// topLevelReturn returns the first return statement of program which is
+// not inside a function, or nil if there is none.
+func topLevelReturn(program *ast.Program) *ast.ReturnStatement {
+	var ret *ast.ReturnStatement
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.ReturnStatement:
+			if ret == nil {
+				ret = node
+			}
+			return false
+		}
+		return ret == nil
+	})
+	return ret
+}
+
+// Exports returns the names exported by the top level statements of program.
+func Exports(program *ast.Program) []string {
+	names := []string{}
+	for _, stmt := range program.Statements {
+		if export, ok := stmt.(*ast.ExportStatement); ok {
+			names = append(names, export.Statement.Name.Value)
+		}
+	}
+	return names
+}
+
+// Loading tracks the modules currently being loaded to detect import cycles.
+type Loading struct {
+	paths []string
+}
+
+// Enter records that path is being loaded. It fails if path is already
+// being loaded, which means it imports itself through the modules loaded
+// since.
+func (l *Loading) Enter(path string) error {
+	for i, loading := range l.paths {
+		if loading == path {
+			cycle := append(append([]string{}, l.paths[i:]...), path)
+			return fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	l.paths = append(l.paths, path)
+	return nil
+}
+
+// Leave records that the module entered last is loaded.
+func (l *Loading) Leave() {
+	l.paths = l.paths[:len(l.paths)-1]
+}
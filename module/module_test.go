@@ -0,0 +1,119 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(contents), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib")
+	writeFile(t, filepath.Join(dir, "app", "local.monkey"), "")
+	writeFile(t, filepath.Join(dir, "app", "shared.monkey"), "")
+	writeFile(t, filepath.Join(lib, "shared.monkey"), "")
+	writeFile(t, filepath.Join(lib, "util", "strings.monkey"), "")
+
+	importer := filepath.Join(dir, "app", "main.monkey")
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"local.monkey", filepath.Join(dir, "app", "local.monkey")},
+		{"./local.monkey", filepath.Join(dir, "app", "local.monkey")},
+		// the directory of the importer comes before the search path
+		{"shared.monkey", filepath.Join(dir, "app", "shared.monkey")},
+		{"util/strings.monkey", filepath.Join(lib, "util", "strings.monkey")},
+		{"../lib/shared.monkey", filepath.Join(lib, "shared.monkey")},
+		{filepath.Join(lib, "shared.monkey"), filepath.Join(lib, "shared.monkey")},
+	}
+
+	for _, tt := range tests {
+		resolved, err := Resolve(tt.path, importer, []string{lib})
+		if err != nil {
+			t.Errorf("%q: Resolve failed: %s", tt.path, err)
+			continue
+		}
+		if resolved != tt.expected {
+			t.Errorf("%q: wrong path. want=%q, got=%q", tt.path, tt.expected, resolved)
+		}
+	}
+
+	for _, path := range []string{"missing.monkey", "util"} {
+		_, err := Resolve(path, importer, []string{lib})
+		expected := `cannot find module "` + path + `"`
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", path, expected, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.monkey")
+	writeFile(t, valid, "export let a = 1; let b = 2; export let c = fn() { b };")
+
+	program, err := Parse(valid)
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
+	}
+	exports := Exports(program)
+	if !reflect.DeepEqual(exports, []string{"a", "c"}) {
+		t.Errorf("wrong exports. want=[a c], got=%v", exports)
+	}
+	if filename := program.Pos().Filename; filename != valid {
+		t.Errorf("wrong filename. want=%q, got=%q", valid, filename)
+	}
+
+	invalid := filepath.Join(dir, "invalid.monkey")
+	writeFile(t, invalid, "let = 1;")
+	_, err = Parse(invalid)
+	if err == nil || !strings.HasPrefix(err.Error(), invalid+":1:5:") {
+		t.Errorf("expected parser error with position, got %v", err)
+	}
+
+	returns := filepath.Join(dir, "returns.monkey")
+	writeFile(t, returns, "export let a = 1;\nif (a) { [fn() { return 1 }, 2] };\nlet b = if (a) { return 5 };")
+	_, err = Parse(returns)
+	expected := returns + ":3:18: return outside of function in module"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+}
+
+func TestLoading(t *testing.T) {
+	var loading Loading
+
+	for _, path := range []string{"a", "b", "c"} {
+		err := loading.Enter(path)
+		if err != nil {
+			t.Fatalf("Enter(%q) failed: %s", path, err)
+		}
+	}
+
+	err := loading.Enter("b")
+	if err == nil || err.Error() != "import cycle: b -> c -> b" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	loading.Leave()
+	loading.Leave()
+	err = loading.Enter("b")
+	if err != nil {
+		t.Errorf("Enter after Leave failed: %s", err)
+	}
+}
@@ -1,6 +1,17 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxStringSize bounds the length of the strings built by builtins, larger
+// results fail instead of exhausting the memory of the host.
+const maxStringSize = 1 << 30
 
 var Builtins = []struct {
 	Name    string
@@ -9,7 +20,7 @@ var Builtins = []struct {
 	{
 		"len",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1",
 						len(args))
@@ -17,7 +28,7 @@ var Builtins = []struct {
 
 				switch arg := args[0].(type) {
 				case *String:
-					return &Integer{Value: int64(len(arg.Value))}
+					return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 				case *Array:
 					return &Integer{Value: int64(len(arg.Elements))}
 				default:
@@ -30,9 +41,9 @@ var Builtins = []struct {
 	{
 		"puts",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				for _, arg := range args {
-					fmt.Println(arg.Inspect())
+					fmt.Fprintln(ctx.Stdout, arg.Inspect())
 				}
 				return nil
 			},
@@ -41,7 +52,7 @@ var Builtins = []struct {
 	{
 		"first",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1",
 						len(args))
@@ -63,7 +74,7 @@ var Builtins = []struct {
 	{
 		"last",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1",
 						len(args))
@@ -86,7 +97,7 @@ var Builtins = []struct {
 	{
 		"rest",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1",
 						len(args))
@@ -99,6 +110,9 @@ var Builtins = []struct {
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
 				if length > 0 {
+					if err := allocate(ctx, int64(length)); err != nil {
+						return err
+					}
 					newElements := make([]Object, length-1)
 					copy(newElements, arr.Elements[1:length])
 					return &Array{Elements: newElements}
@@ -111,7 +125,7 @@ var Builtins = []struct {
 	{
 		"push",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				if len(args) != 2 {
 					return newError("wrong number of arguments. got=%d, want=2",
 						len(args))
@@ -123,6 +137,9 @@ var Builtins = []struct {
 
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
+				if err := allocate(ctx, 2+int64(length)); err != nil {
+					return err
+				}
 
 				newElements := make([]Object, length+1)
 				copy(newElements, arr.Elements)
@@ -132,6 +149,675 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		"int",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer:
+					return arg
+				case *Float:
+					if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) ||
+						arg.Value < math.MinInt64 || arg.Value >= math.MaxInt64 {
+						return newError("cannot convert %s to INTEGER", arg.Inspect())
+					}
+					return &Integer{Value: int64(arg.Value)}
+				case *String:
+					value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 0, 64)
+					if err != nil {
+						return newError("cannot convert %q to INTEGER", arg.Value)
+					}
+					return &Integer{Value: value}
+				default:
+					return newError("argument to `int` not supported, got %s",
+						args[0].Type())
+				}
+			},
+		},
+	},
+	{
+		"float",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer:
+					return &Float{Value: float64(arg.Value)}
+				case *Float:
+					return arg
+				case *String:
+					value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+					if err != nil {
+						return newError("cannot convert %q to FLOAT", arg.Value)
+					}
+					return &Float{Value: value}
+				default:
+					return newError("argument to `float` not supported, got %s",
+						args[0].Type())
+				}
+			},
+		},
+	},
+	{
+		"map",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, err := arrayAndFunction("map", args)
+				if err != nil {
+					return err
+				}
+
+				elements := make([]Object, len(arr.Elements))
+				for i, el := range arr.Elements {
+					result := ctx.Call(fn, el)
+					if isError(result) {
+						return result
+					}
+					elements[i] = result
+				}
+				return &Array{Elements: elements}
+			},
+		},
+	},
+	{
+		"filter",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, err := arrayAndFunction("filter", args)
+				if err != nil {
+					return err
+				}
+
+				elements := []Object{}
+				for _, el := range arr.Elements {
+					result := ctx.Call(fn, el)
+					if isError(result) {
+						return result
+					}
+					if isTruthy(result) {
+						elements = append(elements, el)
+					}
+				}
+				return &Array{Elements: elements}
+			},
+		},
+	},
+	{
+		"reduce",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				if len(args) != 3 {
+					return newError("wrong number of arguments. got=%d, want=3",
+						len(args))
+				}
+				arr, fn, err := arrayAndFunction("reduce", []Object{args[0], args[2]})
+				if err != nil {
+					return err
+				}
+
+				result := args[1]
+				for _, el := range arr.Elements {
+					result = ctx.Call(fn, result, el)
+					if isError(result) {
+						return result
+					}
+				}
+				return result
+			},
+		},
+	},
+	{
+		"each",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, err := arrayAndFunction("each", args)
+				if err != nil {
+					return err
+				}
+
+				for _, el := range arr.Elements {
+					result := ctx.Call(fn, el)
+					if isError(result) {
+						return result
+					}
+				}
+				return nil
+			},
+		},
+	},
+	{
+		"sort",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				if len(args) != 1 && len(args) != 2 {
+					return newError("wrong number of arguments. got=%d, want=1 or 2",
+						len(args))
+				}
+				if args[0].Type() != ARRAY_OBJ {
+					return newError("argument to `sort` must be ARRAY, got %s",
+						args[0].Type())
+				}
+
+				arr := args[0].(*Array)
+				if err := allocate(ctx, 1+int64(len(arr.Elements))); err != nil {
+					return err
+				}
+				elements := make([]Object, len(arr.Elements))
+				copy(elements, arr.Elements)
+
+				less := compareNatural
+				if len(args) == 2 {
+					if !isFunction(args[1]) {
+						return newError("argument to `sort` must be a function, got %s",
+							args[1].Type())
+					}
+					less = func(a, b Object) (bool, *Error) {
+						return compareWith(ctx, args[1], a, b)
+					}
+				}
+
+				var sortErr *Error
+				sort.SliceStable(elements, func(i, j int) bool {
+					if sortErr != nil {
+						return false
+					}
+					result, err := less(elements[i], elements[j])
+					if err != nil {
+						sortErr = err
+					}
+					return result
+				})
+				if sortErr != nil {
+					return sortErr
+				}
+
+				return &Array{Elements: elements}
+			},
+		},
+	},
+	{
+		"find",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, err := arrayAndFunction("find", args)
+				if err != nil {
+					return err
+				}
+
+				for _, el := range arr.Elements {
+					result := ctx.Call(fn, el)
+					if isError(result) {
+						return result
+					}
+					if isTruthy(result) {
+						return el
+					}
+				}
+				return nil
+			},
+		},
+	},
+	{
+		"any",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, err := arrayAndFunction("any", args)
+				if err != nil {
+					return err
+				}
+
+				for _, el := range arr.Elements {
+					result := ctx.Call(fn, el)
+					if isError(result) {
+						return result
+					}
+					if isTruthy(result) {
+						return &Boolean{Value: true}
+					}
+				}
+				return &Boolean{Value: false}
+			},
+		},
+	},
+	{
+		"all",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, err := arrayAndFunction("all", args)
+				if err != nil {
+					return err
+				}
+
+				for _, el := range arr.Elements {
+					result := ctx.Call(fn, el)
+					if isError(result) {
+						return result
+					}
+					if !isTruthy(result) {
+						return &Boolean{Value: false}
+					}
+				}
+				return &Boolean{Value: true}
+			},
+		},
+	},
+	{
+		"split",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				strs, err := stringArguments("split", args, 2)
+				if err != nil {
+					return err
+				}
+				count := strings.Count(strs[0], strs[1]) + 1
+				if strs[1] == "" {
+					count = utf8.RuneCountInString(strs[0])
+				}
+				if err := allocate(ctx, stringArraySize(count, len(strs[0]))); err != nil {
+					return err
+				}
+				return stringArray(strings.Split(strs[0], strs[1]))
+			},
+		},
+	},
+	{
+		"join",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				if len(args) != 2 {
+					return newError("wrong number of arguments. got=%d, want=2",
+						len(args))
+				}
+				arr, ok := args[0].(*Array)
+				if !ok {
+					return newError("argument to `join` must be ARRAY, got %s",
+						args[0].Type())
+				}
+				sep, ok := args[1].(*String)
+				if !ok {
+					return newError("argument to `join` must be STRING, got %s",
+						args[1].Type())
+				}
+
+				elements := make([]string, len(arr.Elements))
+				size := int64(len(sep.Value)) * int64(len(elements)-1)
+				for i, el := range arr.Elements {
+					str, ok := el.(*String)
+					if !ok {
+						return newError("elements joined by `join` must be STRING, got %s",
+							el.Type())
+					}
+					elements[i] = str.Value
+					size += int64(len(str.Value))
+				}
+				if size > maxStringSize {
+					return newError("result of `join` too large: %d bytes", size)
+				}
+				if err := allocate(ctx, 1+size/8); err != nil {
+					return err
+				}
+				return &String{Value: strings.Join(elements, sep.Value)}
+			},
+		},
+	},
+	{
+		"trim",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				strs, err := stringArguments("trim", args, 1)
+				if err != nil {
+					return err
+				}
+				return &String{Value: strings.TrimSpace(strs[0])}
+			},
+		},
+	},
+	{
+		"upper",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				strs, err := stringArguments("upper", args, 1)
+				if err != nil {
+					return err
+				}
+				return &String{Value: strings.ToUpper(strs[0])}
+			},
+		},
+	},
+	{
+		"lower",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				strs, err := stringArguments("lower", args, 1)
+				if err != nil {
+					return err
+				}
+				return &String{Value: strings.ToLower(strs[0])}
+			},
+		},
+	},
+	{
+		"replace",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				strs, err := stringArguments("replace", args, 3)
+				if err != nil {
+					return err
+				}
+				count := int64(strings.Count(strs[0], strs[1]))
+				size := int64(len(strs[0])) + count*int64(len(strs[2])-len(strs[1]))
+				if size > maxStringSize {
+					return newError("result of `replace` too large: %d bytes", size)
+				}
+				if err := allocate(ctx, 1+size/8); err != nil {
+					return err
+				}
+				return &String{Value: strings.ReplaceAll(strs[0], strs[1], strs[2])}
+			},
+		},
+	},
+	{
+		"contains",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				strs, err := stringArguments("contains", args, 2)
+				if err != nil {
+					return err
+				}
+				return &Boolean{Value: strings.Contains(strs[0], strs[1])}
+			},
+		},
+	},
+	{
+		"starts_with",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				strs, err := stringArguments("starts_with", args, 2)
+				if err != nil {
+					return err
+				}
+				return &Boolean{Value: strings.HasPrefix(strs[0], strs[1])}
+			},
+		},
+	},
+	{
+		"ends_with",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				strs, err := stringArguments("ends_with", args, 2)
+				if err != nil {
+					return err
+				}
+				return &Boolean{Value: strings.HasSuffix(strs[0], strs[1])}
+			},
+		},
+	},
+	{
+		"index_of",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				strs, err := stringArguments("index_of", args, 2)
+				if err != nil {
+					return err
+				}
+
+				i := strings.Index(strs[0], strs[1])
+				if i > 0 {
+					// count in characters like len and indexing do
+					i = utf8.RuneCountInString(strs[0][:i])
+				}
+				return &Integer{Value: int64(i)}
+			},
+		},
+	},
+	{
+		"substr",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				if len(args) != 2 && len(args) != 3 {
+					return newError("wrong number of arguments. got=%d, want=2 or 3",
+						len(args))
+				}
+				str, ok := args[0].(*String)
+				if !ok {
+					return newError("argument to `substr` must be STRING, got %s",
+						args[0].Type())
+				}
+				runes := []rune(str.Value)
+
+				start, ok := args[1].(*Integer)
+				if !ok {
+					return newError("argument to `substr` must be INTEGER, got %s",
+						args[1].Type())
+				}
+				if start.Value < 0 || start.Value > int64(len(runes)) {
+					return newError("start of `substr` out of range: %d", start.Value)
+				}
+
+				end := int64(len(runes))
+				if len(args) == 3 {
+					length, ok := args[2].(*Integer)
+					if !ok {
+						return newError("argument to `substr` must be INTEGER, got %s",
+							args[2].Type())
+					}
+					if length.Value < 0 {
+						return newError("length of `substr` must not be negative, got %d",
+							length.Value)
+					}
+					if length.Value < end-start.Value {
+						end = start.Value + length.Value
+					}
+				}
+
+				return &String{Value: string(runes[start.Value:end])}
+			},
+		},
+	},
+	{
+		"repeat",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				if len(args) != 2 {
+					return newError("wrong number of arguments. got=%d, want=2",
+						len(args))
+				}
+				str, ok := args[0].(*String)
+				if !ok {
+					return newError("argument to `repeat` must be STRING, got %s",
+						args[0].Type())
+				}
+				count, ok := args[1].(*Integer)
+				if !ok {
+					return newError("argument to `repeat` must be INTEGER, got %s",
+						args[1].Type())
+				}
+				if count.Value < 0 {
+					return newError("count of `repeat` must not be negative, got %d",
+						count.Value)
+				}
+				if len(str.Value) > 0 && count.Value > maxStringSize/int64(len(str.Value)) {
+					return newError("result of `repeat` too large: %d times %d bytes",
+						count.Value, len(str.Value))
+				}
+				if err := allocate(ctx, 1+count.Value*int64(len(str.Value))/8); err != nil {
+					return err
+				}
+				return &String{Value: strings.Repeat(str.Value, int(count.Value))}
+			},
+		},
+	},
+	{
+		"chars",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				strs, err := stringArguments("chars", args, 1)
+				if err != nil {
+					return err
+				}
+				count := utf8.RuneCountInString(strs[0])
+				if err := allocate(ctx, stringArraySize(count, len(strs[0]))); err != nil {
+					return err
+				}
+				return stringArray(strings.Split(strs[0], ""))
+			},
+		},
+	},
+	{
+		"keys",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				hash, err := hashArgument("keys", args, 1)
+				if err != nil {
+					return err
+				}
+
+				elements := make([]Object, hash.Len())
+				for i, pair := range hash.Pairs() {
+					elements[i] = pair.Key
+				}
+				return &Array{Elements: elements}
+			},
+		},
+	},
+	{
+		"values",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				hash, err := hashArgument("values", args, 1)
+				if err != nil {
+					return err
+				}
+
+				elements := make([]Object, hash.Len())
+				for i, pair := range hash.Pairs() {
+					elements[i] = pair.Value
+				}
+				return &Array{Elements: elements}
+			},
+		},
+	},
+	{
+		"entries",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				hash, err := hashArgument("entries", args, 1)
+				if err != nil {
+					return err
+				}
+
+				elements := make([]Object, hash.Len())
+				for i, pair := range hash.Pairs() {
+					elements[i] = &Array{Elements: []Object{pair.Key, pair.Value}}
+				}
+				return &Array{Elements: elements}
+			},
+		},
+	},
+	{
+		"has",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				hash, err := hashArgument("has", args, 2)
+				if err != nil {
+					return err
+				}
+				key, ok := args[1].(Hashable)
+				if !ok {
+					return newError("unusable as hash key: %s", args[1].Type())
+				}
+
+				_, ok = hash.Get(key)
+				return &Boolean{Value: ok}
+			},
+		},
+	},
+	{
+		"delete",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				hash, err := hashArgument("delete", args, 2)
+				if err != nil {
+					return err
+				}
+				key, ok := args[1].(Hashable)
+				if !ok {
+					return newError("unusable as hash key: %s", args[1].Type())
+				}
+
+				if err := allocate(ctx, 1+int64(hash.Len())); err != nil {
+					return err
+				}
+				result := hash.Copy()
+				result.Delete(key)
+				return result
+			},
+		},
+	},
+	{
+		"remove",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				hash, err := hashArgument("remove", args, 2)
+				if err != nil {
+					return err
+				}
+				key, ok := args[1].(Hashable)
+				if !ok {
+					return newError("unusable as hash key: %s", args[1].Type())
+				}
+
+				value, ok := hash.Get(key)
+				if !ok {
+					return nil
+				}
+				hash.Delete(key)
+				return value
+			},
+		},
+	},
+	{
+		"merge",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				if len(args) < 1 {
+					return newError("wrong number of arguments. got=%d, want=1 or more",
+						len(args))
+				}
+
+				size := int64(1)
+				for _, arg := range args {
+					hash, ok := arg.(*Hash)
+					if !ok {
+						return newError("argument to `merge` must be HASH, got %s",
+							arg.Type())
+					}
+					size += int64(hash.Len())
+				}
+				if err := allocate(ctx, size); err != nil {
+					return err
+				}
+
+				result := NewHash()
+				for _, arg := range args {
+					hash := arg.(*Hash)
+					for _, pair := range hash.Pairs() {
+						result.Set(pair.Key.(Hashable), pair.Value)
+					}
+				}
+				return result
+			},
+		},
+	},
 }
 
 func GetBuiltinByName(name string) *Builtin {
@@ -146,3 +832,146 @@ func GetBuiltinByName(name string) *Builtin {
 func newError(format string, a ...any) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
+
+// allocate reserves size for the objects a builtin is about to create, the
+// error stops the program.
+func allocate(ctx *BuiltinContext, size int64) *Error {
+	if err := ctx.Allocate(size); err != nil {
+		return &Error{Message: err.Error(), Stop: err}
+	}
+	return nil
+}
+
+// arrayAndFunction checks the arguments of the builtins applying a function
+// to the elements of an array.
+func arrayAndFunction(name string, args []Object) (*Array, Object, *Error) {
+	if len(args) != 2 {
+		return nil, nil, newError("wrong number of arguments. got=%d, want=2",
+			len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, nil, newError("argument to `%s` must be ARRAY, got %s",
+			name, args[0].Type())
+	}
+	if !isFunction(args[1]) {
+		return nil, nil, newError("argument to `%s` must be a function, got %s",
+			name, args[1].Type())
+	}
+	return arr, args[1], nil
+}
+
+func isFunction(obj Object) bool {
+	switch obj.(type) {
+	case *Closure, *Function, *Builtin:
+		return true
+	default:
+		return false
+	}
+}
+
+func isError(obj Object) bool {
+	return obj != nil && obj.Type() == ERROR_OBJ
+}
+
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null, nil:
+		return false
+	default:
+		return true
+	}
+}
+
+// compareNatural orders numbers by value and strings lexically.
+func compareNatural(a, b Object) (bool, *Error) {
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value < b.Value, nil
+		case *Float:
+			return float64(a.Value) < b.Value, nil
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value < float64(b.Value), nil
+		case *Float:
+			return a.Value < b.Value, nil
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value < b.Value, nil
+		}
+	}
+	return false, newError("cannot compare %s and %s, pass a comparator to `sort`",
+		a.Type(), b.Type())
+}
+
+// compareWith orders a before b if the comparator returns true or a negative
+// integer.
+func compareWith(ctx *BuiltinContext, cmp, a, b Object) (bool, *Error) {
+	switch result := ctx.Call(cmp, a, b).(type) {
+	case *Error:
+		return false, result
+	case *Boolean:
+		return result.Value, nil
+	case *Integer:
+		return result.Value < 0, nil
+	default:
+		return false, newError("comparator must return BOOLEAN or INTEGER, got %s",
+			result.Type())
+	}
+}
+
+// stringArguments checks that the builtin name got n string arguments and
+// returns their values.
+func stringArguments(name string, args []Object, n int) ([]string, *Error) {
+	if len(args) != n {
+		return nil, newError("wrong number of arguments. got=%d, want=%d",
+			len(args), n)
+	}
+
+	strs := make([]string, n)
+	for i, arg := range args {
+		str, ok := arg.(*String)
+		if !ok {
+			return nil, newError("argument to `%s` must be STRING, got %s",
+				name, arg.Type())
+		}
+		strs[i] = str.Value
+	}
+	return strs, nil
+}
+
+// stringArraySize is the size of an array of count strings with the given
+// number of bytes in total, including the strings.
+func stringArraySize(count, bytes int) int64 {
+	return 1 + 2*int64(count) + int64(bytes)/8
+}
+
+func stringArray(strs []string) *Array {
+	elements := make([]Object, len(strs))
+	for i, str := range strs {
+		elements[i] = &String{Value: str}
+	}
+	return &Array{Elements: elements}
+}
+
+// hashArgument checks that the builtin name got n arguments, the first of
+// them a hash.
+func hashArgument(name string, args []Object, n int) (*Hash, *Error) {
+	if len(args) != n {
+		return nil, newError("wrong number of arguments. got=%d, want=%d",
+			len(args), n)
+	}
+	hash, ok := args[0].(*Hash)
+	if !ok {
+		return nil, newError("argument to `%s` must be HASH, got %s",
+			name, args[0].Type())
+	}
+	return hash, nil
+}
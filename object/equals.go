@@ -0,0 +1,100 @@
+package object
+
+// Equals reports whether a and b are equal values. Numbers compare by value
+// across integers and floats, strings by content and arrays and hashes
+// structurally. All other objects are only equal to themselves.
+func Equals(a, b Object) bool {
+	return equals(a, b, map[objectPair]bool{})
+}
+
+type objectPair struct {
+	a, b Object
+}
+
+// equals keeps track of the arrays and hashes currently being compared.
+// Meeting the same pair again means the values are cyclic; the pair is then
+// considered equal, any difference shows up elsewhere in the comparison.
+func equals(a, b Object, visiting map[objectPair]bool) bool {
+	if a == b {
+		return true
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == b.Value
+		case *Float:
+			return float64(a.Value) == b.Value
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == float64(b.Value)
+		case *Float:
+			return a.Value == b.Value
+		}
+	case *Boolean:
+		if b, ok := b.(*Boolean); ok {
+			return a.Value == b.Value
+		}
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value == b.Value
+		}
+	case *Array:
+		if b, ok := b.(*Array); ok {
+			return arraysEqual(a, b, visiting)
+		}
+	case *Hash:
+		if b, ok := b.(*Hash); ok {
+			return hashesEqual(a, b, visiting)
+		}
+	}
+
+	return false
+}
+
+func arraysEqual(a, b *Array, visiting map[objectPair]bool) bool {
+	if len(a.Elements) != len(b.Elements) {
+		return false
+	}
+
+	pair := objectPair{a, b}
+	if visiting[pair] {
+		return true
+	}
+	visiting[pair] = true
+	defer delete(visiting, pair)
+
+	for i := range a.Elements {
+		if !equals(a.Elements[i], b.Elements[i], visiting) {
+			return false
+		}
+	}
+	return true
+}
+
+func hashesEqual(a, b *Hash, visiting map[objectPair]bool) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+
+	pair := objectPair{a, b}
+	if visiting[pair] {
+		return true
+	}
+	visiting[pair] = true
+	defer delete(visiting, pair)
+
+	for _, ap := range a.Pairs() {
+		bv, ok := b.Get(ap.Key.(Hashable))
+		if !ok || !equals(ap.Value, bv, visiting) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package object
+
+// Hash maps keys to values and remembers the order in which the keys were
+// inserted, which is the order of iterating and printing it. Keys with the
+// same HashKey are told apart by comparing the keys themselves.
+type Hash struct {
+	pairs []HashPair
+
+	// index holds the position of the first pair of each HashKey, the
+	// positions of further pairs whose keys collide with it are kept in
+	// collisions
+	index      map[HashKey]int
+	collisions map[HashKey][]int
+}
+
+func NewHash() *Hash {
+	return &Hash{index: make(map[HashKey]int)}
+}
+
+func (h *Hash) Len() int { return len(h.pairs) }
+
+// Pairs returns the pairs in insertion order. The slice must not be
+// modified.
+func (h *Hash) Pairs() []HashPair { return h.pairs }
+
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	_, i := h.find(key)
+	if i < 0 {
+		return nil, false
+	}
+	return h.pairs[i].Value, true
+}
+
+// Set adds the pair or replaces the value of an existing key, which keeps
+// its position.
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey, i := h.find(key)
+	if i >= 0 {
+		h.pairs[i].Value = value
+		return
+	}
+
+	h.addIndex(hashKey, len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+// Delete removes key and reports whether it was present.
+func (h *Hash) Delete(key Hashable) bool {
+	_, i := h.find(key)
+	if i < 0 {
+		return false
+	}
+
+	h.pairs = append(h.pairs[:i], h.pairs[i+1:]...)
+	h.reindex()
+	return true
+}
+
+// Copy returns a shallow copy of h.
+func (h *Hash) Copy() *Hash {
+	c := &Hash{pairs: make([]HashPair, len(h.pairs))}
+	copy(c.pairs, h.pairs)
+	c.reindex()
+	return c
+}
+
+// find returns the HashKey of key and the position of its pair, which is
+// -1 if there is none.
+func (h *Hash) find(key Hashable) (HashKey, int) {
+	hashKey := key.HashKey()
+
+	i, ok := h.index[hashKey]
+	if !ok {
+		return hashKey, -1
+	}
+	if keysEqual(h.pairs[i].Key, key) {
+		return hashKey, i
+	}
+
+	for _, i := range h.collisions[hashKey] {
+		if keysEqual(h.pairs[i].Key, key) {
+			return hashKey, i
+		}
+	}
+	return hashKey, -1
+}
+
+func (h *Hash) addIndex(hashKey HashKey, i int) {
+	if h.index == nil {
+		h.index = make(map[HashKey]int)
+	}
+
+	if _, ok := h.index[hashKey]; !ok {
+		h.index[hashKey] = i
+		return
+	}
+
+	if h.collisions == nil {
+		h.collisions = make(map[HashKey][]int)
+	}
+	h.collisions[hashKey] = append(h.collisions[hashKey], i)
+}
+
+// reindex rebuilds the index after the positions of pairs changed.
+func (h *Hash) reindex() {
+	h.index = make(map[HashKey]int, len(h.pairs))
+	h.collisions = nil
+
+	for i, pair := range h.pairs {
+		h.addIndex(pair.Key.(Hashable).HashKey(), i)
+	}
+}
+
+// keysEqual compares hash keys, taking a shortcut for the common key types
+// before falling back to Equals.
+func keysEqual(a, b Object) bool {
+	if a == b {
+		return true
+	}
+
+	switch a := a.(type) {
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value == b.Value
+		}
+	case *Integer:
+		if b, ok := b.(*Integer); ok {
+			return a.Value == b.Value
+		}
+	case *Float:
+		// NaN keys are equal to each other, otherwise they could never be
+		// looked up again
+		if b, ok := b.(*Float); ok && a.Value != a.Value {
+			return b.Value != b.Value
+		}
+	}
+	return Equals(a, b)
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string  { return inspect(h, map[Object]bool{}) }
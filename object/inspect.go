@@ -0,0 +1,52 @@
+package object
+
+import (
+	"bytes"
+	"strings"
+)
+
+// inspect returns the Inspect output of obj. It keeps track of the arrays
+// and hashes currently being printed, meeting one of them again means the
+// value is cyclic and it is printed as [...] or {...}.
+func inspect(obj Object, visiting map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		if visiting[obj] {
+			return "[...]"
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+
+		elements := make([]string, len(obj.Elements))
+		for i, element := range obj.Elements {
+			elements[i] = inspect(element, visiting)
+		}
+
+		var out bytes.Buffer
+		out.WriteString("[")
+		out.WriteString(strings.Join(elements, ", "))
+		out.WriteString("]")
+		return out.String()
+
+	case *Hash:
+		if visiting[obj] {
+			return "{...}"
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+
+		pairs := make([]string, len(obj.pairs))
+		for i, pair := range obj.pairs {
+			pairs[i] = pair.Key.Inspect() + ": " + inspect(pair.Value, visiting)
+		}
+
+		var out bytes.Buffer
+		out.WriteString("{")
+		out.WriteString(strings.Join(pairs, ", "))
+		out.WriteString("}")
+		return out.String()
+
+	default:
+		return obj.Inspect()
+	}
+}
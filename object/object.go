@@ -4,8 +4,16 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"io"
+	"math"
 	"monkey/ast"
 	"monkey/code"
+	"monkey/limit"
+	"monkey/module"
+	"monkey/token"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +21,7 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	FLOAT_OBJ             = "FLOAT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	NULL_OBJ              = "NULL"
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
@@ -24,6 +33,12 @@ const (
 	HASH_OBJ              = "HASH"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE_OBJ"
+	CELL_OBJ              = "CELL"
+	EXCEPTION_OBJ         = "EXCEPTION"
+	ITERATOR_OBJ          = "ITERATOR"
+	BREAK_OBJ             = "BREAK"
+	CONTINUE_OBJ          = "CONTINUE"
+	TAIL_CALL_OBJ         = "TAIL_CALL"
 )
 
 type Object interface {
@@ -38,6 +53,19 @@ type Integer struct {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -52,6 +80,21 @@ type String struct {
 func (s *String) Inspect() string  { return s.Value }
 func (s *String) Type() ObjectType { return STRING_OBJ }
 
+// CharAt returns the i-th character of s as string, counting runes rather
+// than bytes.
+func CharAt(s *String, i int64) (*String, bool) {
+	if i < 0 {
+		return nil, false
+	}
+	for _, r := range s.Value {
+		if i == 0 {
+			return &String{Value: string(r)}, true
+		}
+		i--
+	}
+	return nil, false
+}
+
 type Null struct{}
 
 func (n *Null) Inspect() string  { return "null" }
@@ -64,14 +107,99 @@ type ReturnValue struct {
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
+// Break and Continue are the signals the evaluator uses to unwind a loop body.
+type Break struct{}
+
+func (b *Break) Inspect() string  { return "break" }
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+type Continue struct{}
+
+func (c *Continue) Inspect() string  { return "continue" }
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
+// TailCall is a call in tail position the evaluator makes once the calling
+// function returned, so that tail recursion doesn't nest.
+type TailCall struct {
+	Fn   Object
+	Args []Object
+	// Pos is the position of the call, for stack traces
+	Pos token.Position
+}
+
+func (tc *TailCall) Inspect() string  { return "tail call" }
+func (tc *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+
 type Error struct {
 	Message string
+	// Stop is set if the error stops the program, for example because it
+	// exceeded its limits. Scripts can't catch such errors.
+	Stop error
+	// Trace holds the call stack at the point the error was raised,
+	// innermost call first, like the Trace of an Exception.
+	Trace []string
+	// Thrown is the Value of the exception raised by a throw statement.
+	Thrown Object
 }
 
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
+// Exception is the value a catch clause receives. Trace holds the call
+// stack at the point the exception was raised, innermost call first.
+type Exception struct {
+	Message string
+	Trace   []string
+	// Value is the thrown value if it was neither a string nor an
+	// exception, Message only describes it.
+	Value Object
+}
+
+func (e *Exception) Type() ObjectType { return EXCEPTION_OBJ }
+func (e *Exception) Inspect() string  { return "Exception: " + e.Message }
+
+// Caught returns the value a catch clause receives for e, the thrown value
+// if there is one and e otherwise.
+func (e *Exception) Caught() Object {
+	if e.Value != nil {
+		return e.Value
+	}
+	return e
+}
+
+// Get returns the field of the exception named key, or nil if there is no
+// such field.
+func (e *Exception) Get(key string) Object {
+	switch key {
+	case "message":
+		return &String{Value: e.Message}
+	case "trace":
+		elements := make([]Object, len(e.Trace))
+		for i, entry := range e.Trace {
+			elements[i] = &String{Value: entry}
+		}
+		return &Array{Elements: elements}
+	default:
+		return nil
+	}
+}
+
+// NewException returns the exception raised by throwing value. Exceptions
+// are thrown as they are, strings become the message of a new exception and
+// other values its Value.
+func NewException(value Object) *Exception {
+	switch value := value.(type) {
+	case *Exception:
+		return value
+	case *String:
+		return &Exception{Message: value.Value}
+	default:
+		return &Exception{Message: value.Inspect(), Value: value}
+	}
+}
+
 type Function struct {
+	Name       string
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -96,7 +224,35 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
-type BuiltinFunction func(args ...Object) Object
+// BuiltinContext gives builtins access to the interpreter running them.
+type BuiltinContext struct {
+	// Call calls a function object with args. If the call fails, for
+	// example because the function throws, it returns an *Error which the
+	// builtin should return as its result.
+	Call func(fn Object, args ...Object) Object
+	// Allocate reserves size, in the units of Size, for objects the builtin
+	// is about to create. It fails with a *limit.StopError once the program
+	// exceeds its allocation limit. Reserved sizes are deducted from the
+	// size of the result, which is counted once the builtin returned.
+	Allocate func(size int64) error
+	// IO holds the streams of the program, builtins must not use the
+	// streams of the process directly.
+	IO
+}
+
+// IO holds the standard streams of a program.
+type IO struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+}
+
+// StandardIO returns the standard streams of the process.
+func StandardIO() IO {
+	return IO{Stdout: os.Stdout, Stderr: os.Stderr, Stdin: os.Stdin}
+}
+
+type BuiltinFunction func(ctx *BuiltinContext, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -109,6 +265,13 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+
+	// debug information used for error reporting and disassembling
+	Name       string
+	Filename   string
+	Lines      code.LineTable
+	LocalNames []string
+	FreeNames  []string
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -122,28 +285,76 @@ type Closure struct {
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
 func (c *Closure) Inspect() string  { return fmt.Sprintf("Closure[%p]", c) }
 
+// Cell holds a variable captured by a closure. The closure and the frame
+// defining the variable share the cell, so assignments are seen by both.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return fmt.Sprintf("Cell[%p]", c) }
+
 type Array struct {
 	Elements []Object
 }
 
 func (arr *Array) Type() ObjectType { return ARRAY_OBJ }
-func (arr *Array) Inspect() string {
-	var out bytes.Buffer
+func (arr *Array) Inspect() string  { return inspect(arr, map[Object]bool{}) }
 
-	out.WriteString("[")
-	if len(arr.Elements) > 0 {
-		last := len(arr.Elements) - 1
-		for i := 0; i < last; i++ {
-			out.WriteString(arr.Elements[i].Inspect() + ", ")
+// Iterator walks over a snapshot of the elements of an iterable object.
+// Arrays yield their elements and hashes yield their keys.
+type Iterator struct {
+	Elements []Object
+	index    int
+}
+
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return &Iterator{Elements: obj.Elements}, true
+	case *Hash:
+		keys := make([]Object, 0, obj.Len())
+		for _, pair := range obj.Pairs() {
+			keys = append(keys, pair.Key)
 		}
-		out.WriteString(arr.Elements[last].Inspect())
+		return &Iterator{Elements: keys}, true
+	default:
+		return nil, false
 	}
-	out.WriteString("]")
+}
 
-	return out.String()
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return fmt.Sprintf("Iterator[%p]", it) }
+
+func (it *Iterator) Next() (Object, bool) {
+	if it.index >= len(it.Elements) {
+		return nil, false
+	}
+	el := it.Elements[it.index]
+	it.index++
+	return el, true
+}
+
+// Size returns the size of obj counted against the allocation limit of a
+// program. Every object counts one, arrays, hashes and closures one more
+// per element and strings one more per 8 bytes.
+func Size(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return 1 + int64(len(obj.Value))/8
+	case *Array:
+		return 1 + int64(len(obj.Elements))
+	case *Hash:
+		return 1 + int64(obj.Len())
+	case *Closure:
+		return 1 + int64(len(obj.Free))
+	default:
+		return 1
+	}
 }
 
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
@@ -152,27 +363,6 @@ type HashPair struct {
 	Value Object
 }
 
-type Hash struct {
-	Pairs map[HashKey]HashPair
-}
-
-func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
-	var out bytes.Buffer
-
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
-	}
-
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-
-	return out.String()
-}
-
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
@@ -195,6 +385,16 @@ func (i *Integer) HashKey() HashKey {
 	}
 }
 
+// HashKey of a float with an integral value is the key of the equal integer,
+// so that 1 and 1.0 address the same entry of a hash.
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) &&
+		f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+		return (&Integer{Value: int64(f.Value)}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -204,17 +404,73 @@ func (s *String) HashKey() HashKey {
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	// number of function calls leading to this environment
+	depth int
+	// call this environment was created for, nil at the top level
+	frame *Frame
+
+	modules *Modules
+	// budget of the running program, taken from the caller on calls
+	budget *limit.Budget
+	// streams of the running program, taken from the caller on calls
+	io IO
+}
+
+// Modules holds the modules loaded by a program, all environments of the
+// program share it.
+type Modules struct {
+	SearchPath []string
+	// Loaded maps the path of each loaded module to its exports.
+	Loaded  map[string]*Hash
+	Loading module.Loading
+}
+
+// Frame describes the call of a function or module an environment was
+// created for.
+type Frame struct {
+	Function string
+	// Caller is the environment the call was made in, at CallPos.
+	Caller  *Environment
+	CallPos token.Position
+}
+
+// NewCallEnvironment returns the environment for the call of a function
+// defined in outer, which is nested one level deeper than the caller.
+func NewCallEnvironment(outer *Environment, frame *Frame) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.depth = frame.Caller.depth + 1
+	env.frame = frame
+	env.budget = frame.Caller.budget
+	env.io = frame.Caller.io
+	return env
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.modules = outer.modules
+	env.budget = outer.budget
+	env.io = outer.io
+	return env
+}
+
+// NewModuleEnvironment returns the top level environment of a module
+// imported from importer at pos. It shares nothing but the loaded modules.
+func NewModuleEnvironment(importer *Environment, pos token.Position) *Environment {
+	env := NewEnvironment()
+	env.depth = importer.depth
+	env.frame = &Frame{Function: "<module>", Caller: importer, CallPos: pos}
+	env.modules = importer.modules
+	env.budget = importer.budget
+	env.io = importer.io
 	return env
 }
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	modules := &Modules{Loaded: map[string]*Hash{}}
+	return &Environment{store: s, outer: nil, modules: modules, io: StandardIO()}
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -229,3 +485,61 @@ func (e *Environment) Set(name string, value Object) Object {
 	e.store[name] = value
 	return value
 }
+
+// Names returns the sorted names bound in e, without those of its outer
+// environments.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *Environment) Depth() int {
+	return e.depth
+}
+
+// Frame returns the call e was created for, or nil for the top level
+// environment of the program.
+func (e *Environment) Frame() *Frame {
+	return e.frame
+}
+
+func (e *Environment) Modules() *Modules {
+	return e.modules
+}
+
+func (e *Environment) Budget() *limit.Budget {
+	return e.budget
+}
+
+// SetBudget sets the budget of the program evaluated in e and the
+// environments created from it afterwards.
+func (e *Environment) SetBudget(budget *limit.Budget) {
+	e.budget = budget
+}
+
+func (e *Environment) IO() IO {
+	return e.io
+}
+
+// SetIO sets the streams of the program evaluated in e and the environments
+// created from it afterwards. It defaults to StandardIO.
+func (e *Environment) SetIO(io IO) {
+	e.io = io
+}
+
+// Assign updates the binding of name in the innermost environment defining
+// it and reports whether such a binding exists.
+func (e *Environment) Assign(name string, value Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = value
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, value)
+	}
+	return false
+}
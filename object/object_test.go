@@ -1,6 +1,8 @@
 package object
 
 import (
+	"fmt"
+	"math"
 	"testing"
 )
 
@@ -30,3 +32,290 @@ func TestStringHashKey(t *testing.T) {
 		}
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 2}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("integral float has different hash key than equal integer")
+	}
+	if (&Float{Value: 2.5}).HashKey() != (&Float{Value: 2.5}).HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+	if (&Float{Value: 2.5}).HashKey() == (&Float{Value: 3.5}).HashKey() {
+		t.Errorf("floats with different value have same hash keys")
+	}
+	if (&Float{Value: 0}).HashKey() != (&Float{Value: math.Copysign(0, -1)}).HashKey() {
+		t.Errorf("0.0 and -0.0 have different hash keys")
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	key := func(s string) *String { return &String{Value: s} }
+
+	hash := NewHash()
+	hash.Set(key("c"), &Integer{Value: 1})
+	hash.Set(key("a"), &Integer{Value: 2})
+	hash.Set(key("b"), &Integer{Value: 3})
+	hash.Set(key("a"), &Integer{Value: 4})
+
+	if actual := hash.Inspect(); actual != "{c: 1, a: 4, b: 3}" {
+		t.Fatalf("wrong inspect. got=%q", actual)
+	}
+
+	if !hash.Delete(key("c")) {
+		t.Fatalf("Delete reported missing key")
+	}
+	if hash.Delete(key("c")) {
+		t.Fatalf("Delete reported deleted key as present")
+	}
+	hash.Set(key("c"), &Integer{Value: 5})
+
+	if actual := hash.Inspect(); actual != "{a: 4, b: 3, c: 5}" {
+		t.Fatalf("wrong inspect after delete. got=%q", actual)
+	}
+
+	value, ok := hash.Get(key("b"))
+	if !ok || value.Inspect() != "3" {
+		t.Fatalf("wrong value for b. got=%v", value)
+	}
+
+	copied := hash.Copy()
+	copied.Delete(key("a"))
+	if hash.Len() != 3 || copied.Len() != 2 {
+		t.Fatalf("copy shares pairs. got len=%d, copied len=%d",
+			hash.Len(), copied.Len())
+	}
+}
+
+// collidingKey is a string whose HashKey collides with all other
+// collidingKeys.
+type collidingKey struct {
+	String
+}
+
+func (ck *collidingKey) HashKey() HashKey {
+	return HashKey{Type: STRING_OBJ, Value: 42}
+}
+
+func TestHashCollisions(t *testing.T) {
+	a := &collidingKey{String{Value: "a"}}
+	b := &collidingKey{String{Value: "b"}}
+	c := &collidingKey{String{Value: "c"}}
+
+	hash := NewHash()
+	hash.Set(a, &Integer{Value: 1})
+	hash.Set(b, &Integer{Value: 2})
+	hash.Set(c, &Integer{Value: 3})
+
+	if hash.Len() != 3 {
+		t.Fatalf("colliding keys overwrote each other. got len=%d", hash.Len())
+	}
+
+	tests := []struct {
+		key      Hashable
+		expected string
+	}{
+		{a, "1"},
+		{b, "2"},
+		{c, "3"},
+		{&collidingKey{String{Value: "d"}}, ""},
+	}
+
+	for _, tt := range tests {
+		value, ok := hash.Get(tt.key)
+		if tt.expected == "" {
+			if ok {
+				t.Errorf("unexpected value for missing key. got=%s", value.Inspect())
+			}
+			continue
+		}
+		if !ok || value.Inspect() != tt.expected {
+			t.Errorf("wrong value for %s. want=%s, got=%v",
+				tt.key.Inspect(), tt.expected, value)
+		}
+	}
+
+	hash.Delete(a)
+	value, ok := hash.Get(c)
+	if !ok || value.Inspect() != "3" {
+		t.Errorf("lost colliding key after delete. got=%v", value)
+	}
+	if _, ok := hash.Get(a); ok {
+		t.Errorf("deleted key still present")
+	}
+}
+
+func TestHashNumericKeys(t *testing.T) {
+	hash := NewHash()
+	hash.Set(&Integer{Value: 1}, &String{Value: "int"})
+	hash.Set(&Float{Value: 1}, &String{Value: "float"})
+	hash.Set(&Float{Value: math.NaN()}, &String{Value: "nan"})
+
+	if hash.Len() != 2 {
+		t.Fatalf("wrong len. want=2, got=%d", hash.Len())
+	}
+	if value, _ := hash.Get(&Integer{Value: 1}); value.Inspect() != "float" {
+		t.Errorf("1.0 did not replace 1. got=%s", value.Inspect())
+	}
+	if _, ok := hash.Get(&Float{Value: math.NaN()}); !ok {
+		t.Errorf("NaN key not found")
+	}
+}
+
+var benchmarkResult Object
+
+// benchmarkKeys returns the keys used by the hash benchmarks. BenchmarkMapGet
+// shows the cost of a lookup in a plain map, which doesn't resolve
+// collisions, for comparison with BenchmarkHashGet.
+func benchmarkKeys() []*String {
+	keys := make([]*String, 1000)
+	for i := range keys {
+		keys[i] = &String{Value: fmt.Sprintf("key%d", i)}
+	}
+	return keys
+}
+
+func BenchmarkHashGet(b *testing.B) {
+	keys := benchmarkKeys()
+	hash := NewHash()
+	for i, key := range keys {
+		hash.Set(key, &Integer{Value: int64(i)})
+	}
+
+	lookups := benchmarkKeys()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchmarkResult, _ = hash.Get(lookups[i%len(lookups)])
+	}
+}
+
+func BenchmarkMapGet(b *testing.B) {
+	keys := benchmarkKeys()
+	pairs := make(map[HashKey]HashPair)
+	for i, key := range keys {
+		pairs[key.HashKey()] = HashPair{Key: key, Value: &Integer{Value: int64(i)}}
+	}
+
+	lookups := benchmarkKeys()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// the interface call is what the VM did before with a plain map
+		var key Hashable = lookups[i%len(lookups)]
+		benchmarkResult = pairs[key.HashKey()].Value
+	}
+}
+
+func BenchmarkHashSet(b *testing.B) {
+	keys := benchmarkKeys()
+	value := &Integer{Value: 1}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hash := NewHash()
+		for _, key := range keys {
+			hash.Set(key, value)
+		}
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1, "1.0"},
+		{3.14, "3.14"},
+		{-0.5, "-0.5"},
+		{1e-9, "1e-09"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		actual := (&Float{Value: tt.value}).Inspect()
+		if actual != tt.expected {
+			t.Errorf("wrong inspect. want=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestCyclicInspect(t *testing.T) {
+	one := &Integer{Value: 1}
+
+	array := &Array{Elements: []Object{one, nil}}
+	array.Elements[1] = array
+
+	hash := NewHash()
+	hash.Set(&String{Value: "self"}, hash)
+	hash.Set(&String{Value: "array"}, array)
+
+	// the same array twice is no cycle
+	shared := &Array{Elements: []Object{one}}
+	twice := &Array{Elements: []Object{shared, shared}}
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{array, "[1, [...]]"},
+		{hash, "{self: {...}, array: [1, [...]]}"},
+		{twice, "[[1], [1]]"},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.obj.Inspect(); actual != tt.expected {
+			t.Errorf("wrong inspect. want=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestEquals(t *testing.T) {
+	str := func(s string) *String { return &String{Value: s} }
+	arr := func(elements ...Object) *Array { return &Array{Elements: elements} }
+	hash := func(key *String, value Object) *Hash {
+		h := NewHash()
+		h.Set(key, value)
+		return h
+	}
+
+	cyclic := func() *Array {
+		a := arr(&Integer{Value: 1}, nil)
+		a.Elements[1] = a
+		return a
+	}
+
+	fn := &Builtin{}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 1}, true},
+		{&Integer{Value: 1}, &Float{Value: 1}, true},
+		{&Float{Value: 1.5}, &Float{Value: 1.5}, true},
+		{&Integer{Value: 1}, &Integer{Value: 2}, false},
+		{&Boolean{Value: true}, &Boolean{Value: true}, true},
+		{&Null{}, &Null{}, true},
+		{str("a"), str("a"), true},
+		{str("a"), str("b"), false},
+		{str("1"), &Integer{Value: 1}, false},
+		{arr(str("a"), &Integer{Value: 1}), arr(str("a"), &Integer{Value: 1}), true},
+		{arr(str("a")), arr(str("a"), str("b")), false},
+		{arr(arr(str("a"))), arr(arr(str("b"))), false},
+		{hash(str("k"), arr(str("v"))), hash(str("k"), arr(str("v"))), true},
+		{hash(str("k"), str("v")), hash(str("k"), str("w")), false},
+		{hash(str("k"), str("v")), hash(str("j"), str("v")), false},
+		{cyclic(), cyclic(), true},
+		{fn, fn, true},
+		{fn, &Builtin{}, false},
+	}
+
+	for i, tt := range tests {
+		if got := Equals(tt.a, tt.b); got != tt.expected {
+			t.Errorf("tests[%d] - Equals(%T, %T) wrong. want=%t, got=%t",
+				i, tt.a, tt.b, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/token"
+)
+
+// ParseError describes a single syntax error. Expected is empty if the error
+// was not caused by a missing token.
+type ParseError struct {
+	Pos      token.Position
+	Expected token.TokenType
+	Found    token.Token
+	Message  string
+}
+
+func (pe *ParseError) Error() string {
+	return pe.Pos.String() + ": " + pe.Message
+}
+
+var statementKeywords = map[token.TokenType]bool{
+	token.LET:      true,
+	token.RETURN:   true,
+	token.WHILE:    true,
+	token.FOR:      true,
+	token.BREAK:    true,
+	token.CONTINUE: true,
+	token.TRY:      true,
+	token.THROW:    true,
+	token.IMPORT:   true,
+	token.EXPORT:   true,
+}
+
+func (parser *Parser) Errors() []string {
+	errors := make([]string, len(parser.errors))
+	for i, err := range parser.errors {
+		errors[i] = err.Error()
+	}
+	return errors
+}
+
+func (parser *Parser) ParseErrors() []*ParseError {
+	return parser.errors
+}
+
+// addError records err unless the parser is already recovering from an
+// earlier error in the same statement. Follow-up errors are almost always
+// caused by the first one and would only add noise.
+func (parser *Parser) addError(err *ParseError) {
+	if parser.panicking {
+		return
+	}
+	parser.panicking = true
+	parser.errors = append(parser.errors, err)
+}
+
+func (parser *Parser) peekError(t token.TokenType) {
+	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
+		t, parser.peekToken.Type)
+	parser.addError(&ParseError{
+		Pos:      parser.peekToken.Pos,
+		Expected: t,
+		Found:    parser.peekToken,
+		Message:  msg,
+	})
+}
+
+func (parser *Parser) noPrefixParseFnError(t token.TokenType) {
+	msg := fmt.Sprintf("no prefix parse function for %s found", t)
+	parser.addError(&ParseError{
+		Pos:     parser.currentToken.Pos,
+		Found:   parser.currentToken,
+		Message: msg,
+	})
+}
+
+func (parser *Parser) currentTokenError(format string, a ...any) {
+	parser.addError(&ParseError{
+		Pos:     parser.currentToken.Pos,
+		Found:   parser.currentToken,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// unterminatedCommentError reports the /* comment tok running to the end of
+// the input. It is reported even while recovering, as it is unrelated to the
+// statement it is found in.
+func (parser *Parser) unterminatedCommentError(tok token.Token) {
+	parser.panicking = true
+	parser.errors = append(parser.errors, &ParseError{
+		Pos:     tok.Pos,
+		Found:   tok,
+		Message: "unterminated block comment, missing */",
+	})
+}
+
+// synchronize skips tokens after an error until the start of the next
+// statement, that is after a ';', before a statement keyword or before the
+// '}' closing the enclosing block. start and depth describe the first token
+// of the broken statement and the brace depth it was found at.
+func (parser *Parser) synchronize(start token.Token, depth int) {
+	parser.panicking = false
+
+	if parser.currentToken == start {
+		parser.nextToken()
+	}
+
+	for !parser.currentTokenIs(token.EOF) {
+		switch {
+		case parser.currentTokenIs(token.SEMICOLON):
+			parser.nextToken()
+			return
+		case parser.currentTokenIs(token.RBRACE) && parser.depth <= depth:
+			return
+		case statementKeywords[parser.currentToken.Type]:
+			return
+		}
+		parser.nextToken()
+	}
+}
@@ -1,11 +1,11 @@
 package parser
 
 import (
-	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
 	"monkey/token"
 	"strconv"
+	"strings"
 )
 
 type (
@@ -15,11 +15,20 @@ type (
 
 type Parser struct {
 	lexer  *lexer.Lexer
-	errors []string
+	errors []*ParseError
+
+	// panicking is set after an error until the parser has found the
+	// start of the next statement
+	panicking bool
+	// depth counts the braces opened before currentToken
+	depth int
 
 	currentToken token.Token
 	peekToken    token.Token
 
+	// comments read from the lexer, which the parser skips
+	comments []*ast.Comment
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
@@ -27,6 +36,7 @@ type Parser struct {
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
@@ -37,18 +47,24 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NEQ:      EQUALS,
-	token.LEQ:      LESSGREATER,
-	token.GEQ:      LESSGREATER,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.EQ:              EQUALS,
+	token.NEQ:             EQUALS,
+	token.LEQ:             LESSGREATER,
+	token.GEQ:             LESSGREATER,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
+	token.DOT:             INDEX,
 }
 
 func (parser *Parser) peekPrecendence() int {
@@ -76,7 +92,7 @@ func (parser *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn)
 func New(lexer *lexer.Lexer) *Parser {
 	parser := &Parser{
 		lexer:  lexer,
-		errors: []string{},
+		errors: []*ParseError{},
 	}
 	parser.nextToken()
 	parser.nextToken()
@@ -84,6 +100,7 @@ func New(lexer *lexer.Lexer) *Parser {
 	parser.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	parser.registerPrefix(token.IDENTIFIER, parser.parseIdentifier)
 	parser.registerPrefix(token.INT, parser.parseIntegerLiteral)
+	parser.registerPrefix(token.FLOAT, parser.parseFloatLiteral)
 	parser.registerPrefix(token.BANG, parser.parsePrefixExpression)
 	parser.registerPrefix(token.MINUS, parser.parsePrefixExpression)
 	parser.registerPrefix(token.TRUE, parser.parseBoolean)
@@ -108,13 +125,44 @@ func New(lexer *lexer.Lexer) *Parser {
 	parser.registerInfix(token.LT, parser.parseInfixExpression)
 	parser.registerInfix(token.LPAREN, parser.parseCallExpression)
 	parser.registerInfix(token.LBRACKET, parser.parseIndexExpression)
+	parser.registerInfix(token.DOT, parser.parseMemberExpression)
+	parser.registerInfix(token.ASSIGN, parser.parseAssignExpression)
+	parser.registerInfix(token.PLUS_ASSIGN, parser.parseAssignExpression)
+	parser.registerInfix(token.MINUS_ASSIGN, parser.parseAssignExpression)
+	parser.registerInfix(token.ASTERISK_ASSIGN, parser.parseAssignExpression)
+	parser.registerInfix(token.SLASH_ASSIGN, parser.parseAssignExpression)
 
 	return parser
 }
 
 func (parser *Parser) nextToken() {
+	switch parser.currentToken.Type {
+	case token.LBRACE:
+		parser.depth++
+	case token.RBRACE:
+		parser.depth--
+	}
+
 	parser.currentToken = parser.peekToken
-	parser.peekToken = parser.lexer.NextToken()
+	parser.peekToken = parser.readToken()
+}
+
+// readToken returns the next token of the lexer which is not a comment.
+func (parser *Parser) readToken() token.Token {
+	for {
+		tok := parser.lexer.NextToken()
+		if tok.Type == token.ILLEGAL && strings.HasPrefix(tok.Literal, "/*") {
+			parser.unterminatedCommentError(tok)
+			continue
+		}
+		if tok.Type != token.COMMENT {
+			return tok
+		}
+
+		// peekToken is the token read before the comment
+		trailing := parser.peekToken.Type != "" && parser.peekToken.Pos.Line == tok.Pos.Line
+		parser.comments = append(parser.comments, &ast.Comment{Token: tok, Trailing: trailing})
+	}
 }
 
 func (parser *Parser) ParseProgram() *ast.Program {
@@ -122,13 +170,19 @@ func (parser *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for !parser.currentTokenIs(token.EOF) {
+		start, depth := parser.currentToken, parser.depth
 		stmt := parser.parseStatement()
+		if parser.panicking {
+			parser.synchronize(start, depth)
+			continue
+		}
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		parser.nextToken()
 	}
 
+	program.Comments = parser.comments
 	return program
 }
 
@@ -138,6 +192,22 @@ func (parser *Parser) parseStatement() ast.Statement {
 		return parser.parseLetStatement()
 	case token.RETURN:
 		return parser.parseReturnStatement()
+	case token.WHILE:
+		return parser.parseWhileStatement()
+	case token.FOR:
+		return parser.parseForStatement()
+	case token.BREAK:
+		return parser.parseBreakStatement()
+	case token.CONTINUE:
+		return parser.parseContinueStatement()
+	case token.TRY:
+		return parser.parseTryStatement()
+	case token.THROW:
+		return parser.parseThrowStatement()
+	case token.IMPORT:
+		return parser.parseImportStatement()
+	case token.EXPORT:
+		return parser.parseExportStatement()
 	default:
 		return parser.parseExpressionStatement()
 	}
@@ -187,6 +257,207 @@ func (parser *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (parser *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: parser.currentToken}
+
+	if !parser.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	parser.nextToken()
+	stmt.Condition = parser.parseExpression(LOWEST)
+
+	if !parser.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !parser.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = parser.parseBlockStatement()
+
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
+	}
+
+	return stmt
+}
+
+func (parser *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: parser.currentToken}
+
+	if !parser.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !parser.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+
+	stmt.Variable = &ast.Identifier{
+		Token: parser.currentToken,
+		Value: parser.currentToken.Literal,
+	}
+
+	if !parser.expectPeek(token.IN) {
+		return nil
+	}
+
+	parser.nextToken()
+	stmt.Iterable = parser.parseExpression(LOWEST)
+
+	if !parser.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !parser.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = parser.parseBlockStatement()
+
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
+	}
+
+	return stmt
+}
+
+func (parser *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: parser.currentToken}
+
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
+	}
+
+	return stmt
+}
+
+func (parser *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: parser.currentToken}
+
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
+	}
+
+	return stmt
+}
+
+func (parser *Parser) parseTryStatement() *ast.TryStatement {
+	stmt := &ast.TryStatement{Token: parser.currentToken}
+
+	if !parser.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Block = parser.parseBlockStatement()
+
+	if parser.peekTokenIs(token.CATCH) {
+		parser.nextToken()
+
+		if !parser.expectPeek(token.LPAREN) {
+			return nil
+		}
+
+		if !parser.expectPeek(token.IDENTIFIER) {
+			return nil
+		}
+
+		stmt.CatchParameter = &ast.Identifier{
+			Token: parser.currentToken,
+			Value: parser.currentToken.Literal,
+		}
+
+		if !parser.expectPeek(token.RPAREN) {
+			return nil
+		}
+
+		if !parser.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		stmt.Catch = parser.parseBlockStatement()
+	}
+
+	if parser.peekTokenIs(token.FINALLY) {
+		parser.nextToken()
+
+		if !parser.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		stmt.Finally = parser.parseBlockStatement()
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		parser.peekError(token.CATCH)
+		return nil
+	}
+
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
+	}
+
+	return stmt
+}
+
+func (parser *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: parser.currentToken}
+
+	parser.nextToken()
+	stmt.Value = parser.parseExpression(LOWEST)
+
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
+	}
+
+	return stmt
+}
+
+func (parser *Parser) parseImportStatement() *ast.ImportStatement {
+	stmt := &ast.ImportStatement{Token: parser.currentToken}
+
+	if !parser.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Path = &ast.StringLiteral{Token: parser.currentToken, Value: parser.currentToken.Literal}
+
+	if !parser.expectPeek(token.AS) {
+		return nil
+	}
+	if !parser.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	stmt.Alias = &ast.Identifier{Token: parser.currentToken, Value: parser.currentToken.Literal}
+
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
+	}
+
+	return stmt
+}
+
+func (parser *Parser) parseExportStatement() *ast.ExportStatement {
+	stmt := &ast.ExportStatement{Token: parser.currentToken}
+
+	if parser.depth > 0 {
+		parser.currentTokenError("export is only allowed at the top level")
+		return nil
+	}
+
+	if !parser.expectPeek(token.LET) {
+		return nil
+	}
+
+	stmt.Statement = parser.parseLetStatement()
+	if stmt.Statement == nil {
+		return nil
+	}
+
+	return stmt
+}
+
 func (parser *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: parser.currentToken}
 
@@ -208,8 +479,23 @@ func (parser *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(parser.currentToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer.", parser.currentToken.Literal)
-		parser.errors = append(parser.errors, msg)
+		parser.currentTokenError("could not parse %q as integer.",
+			parser.currentToken.Literal)
+		return nil
+	}
+
+	literal.Value = value
+
+	return literal
+}
+
+func (parser *Parser) parseFloatLiteral() ast.Expression {
+	literal := &ast.FloatLiteral{Token: parser.currentToken}
+
+	value, err := strconv.ParseFloat(parser.currentToken.Literal, 64)
+	if err != nil {
+		parser.currentTokenError("could not parse %q as float.",
+			parser.currentToken.Literal)
 		return nil
 	}
 
@@ -346,9 +632,31 @@ func (parser *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (parser *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		parser.currentTokenError("cannot assign to %s", target.String())
+		return nil
+	}
+
+	expression := &ast.AssignExpression{
+		Token:    parser.currentToken,
+		Operator: parser.currentToken.Literal,
+		Target:   target,
+	}
+
+	// assignments are right associative, so a = b = c assigns c to b first
+	parser.nextToken()
+	expression.Value = parser.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 func (parser *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	expr := &ast.CallExpression{Token: parser.currentToken, Function: function}
 	expr.Arguments = parser.parseExpressionList(token.RPAREN)
+	expr.End = parser.currentToken.Pos
 	return expr
 }
 
@@ -365,6 +673,17 @@ func (parser *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return expr
 }
 
+func (parser *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	expr := &ast.MemberExpression{Token: parser.currentToken, Object: left}
+
+	if !parser.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	expr.Property = &ast.Identifier{Token: parser.currentToken, Value: parser.currentToken.Literal}
+
+	return expr
+}
+
 func (parser *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: parser.currentToken}
 	block.Statements = []ast.Statement{}
@@ -372,13 +691,19 @@ func (parser *Parser) parseBlockStatement() *ast.BlockStatement {
 	parser.nextToken()
 
 	for !parser.currentTokenIs(token.RBRACE) && !parser.currentTokenIs(token.EOF) {
+		start, depth := parser.currentToken, parser.depth
 		stmt := parser.parseStatement()
+		if parser.panicking {
+			parser.synchronize(start, depth)
+			continue
+		}
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		parser.nextToken()
 	}
 
+	block.End = parser.currentToken.Pos
 	return block
 }
 
@@ -389,6 +714,7 @@ func (parser *Parser) parseStringLiteral() ast.Expression {
 func (parser *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: parser.currentToken}
 	array.Elements = parser.parseExpressionList(token.RBRACKET)
+	array.End = parser.currentToken.Pos
 	return array
 }
 
@@ -408,6 +734,7 @@ func (parser *Parser) parseHashLiteral() ast.Expression {
 		value := parser.parseExpression(LOWEST)
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !parser.peekTokenIs(token.RBRACE) && !parser.expectPeek(token.COMMA) {
 			return nil
@@ -418,6 +745,7 @@ func (parser *Parser) parseHashLiteral() ast.Expression {
 		return nil
 	}
 
+	hash.End = parser.currentToken.Pos
 	return hash
 }
 
@@ -434,6 +762,10 @@ func (parser *Parser) parseExpressionList(end token.TokenType) []ast.Expression
 
 	for parser.peekTokenIs(token.COMMA) {
 		parser.nextToken()
+		// a trailing comma, like in lists spanning multiple lines
+		if parser.peekTokenIs(end) {
+			break
+		}
 		parser.nextToken()
 		list = append(list, parser.parseExpression(LOWEST))
 	}
@@ -463,16 +795,6 @@ func (parser *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
-func (parser *Parser) Errors() []string {
-	return parser.errors
-}
-
-func (parser *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, parser.peekToken.Type)
-	parser.errors = append(parser.errors, msg)
-}
-
 func (parser *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := parser.prefixParseFns[parser.currentToken.Type]
 	if prefix == nil {
@@ -493,8 +815,3 @@ func (parser *Parser) parseExpression(precedence int) ast.Expression {
 
 	return leftExpr
 }
-
-func (parser *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	parser.errors = append(parser.errors, msg)
-}
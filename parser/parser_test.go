@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/token"
 	"testing"
 )
 
@@ -222,6 +223,43 @@ func TestIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E+3", 2500},
+	}
+
+	for _, tt := range tests {
+		lexer := lexer.New(tt.input)
+		parser := New(lexer)
+		program := parser.ParseProgram()
+		checkParserErrors(t, parser)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not enough statements. got=%d",
+				len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.FloatLiteral. got=%T",
+				stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -344,6 +382,8 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"add(a + b + c * d / f + g)", "add((((a + b) + ((c * d) / f)) + g))"},
 		{"a * [1, 2, 3, 4][b * c] * d", "((a * ([1, 2, 3, 4][(b * c)])) * d)"},
 		{"add(a * b[2], b[1], 2 * [1, 2][1])", "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))"},
+		{"a.b.c(1) * -d.e", "(((a.b).c)(1) * (-(d.e)))"},
+		{"a.b[c.d]", "((a.b)[(c.d)])"},
 	}
 
 	for _, tt := range tests {
@@ -711,6 +751,10 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 
 		testIntegerLiteral(t, v, expected[literal.String()])
 	}
+
+	if hash.String() != "{one:1, two:2, three:3}" {
+		t.Errorf("keys not in source order. got=%q", hash.String())
+	}
 }
 
 func TestParsingHashLiteralsBooleanKeys(t *testing.T) {
@@ -916,6 +960,302 @@ func TestFunctionLiteralWithName(t *testing.T) {
 	}
 }
 
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedTarget string
+		operator       string
+		expected       string
+	}{
+		{"x = 5;", "x", "=", "x = 5"},
+		{"x += y * 2;", "x", "+=", "x += (y * 2)"},
+		{"a[1] -= 3;", "(a[1])", "-=", "(a[1]) -= 3"},
+		{"x = y = 1;", "x", "=", "x = y = 1"},
+		{"h[\"k\"] *= 2;", "(h[k])", "*=", "(h[k]) *= 2"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d",
+				len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+
+		expr, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.AssignExpression. got=%T",
+				stmt.Expression)
+		}
+
+		if expr.Target.String() != tt.expectedTarget {
+			t.Errorf("expr.Target wrong. want=%q, got=%q",
+				tt.expectedTarget, expr.Target.String())
+		}
+
+		if expr.Operator != tt.operator {
+			t.Errorf("expr.Operator wrong. want=%q, got=%q",
+				tt.operator, expr.Operator)
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q",
+				tt.expected, program.String())
+		}
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	l := lexer.New("1 = 2;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. want=1, got=%d (%v)", len(errors), errors)
+	}
+
+	if errors[0] != "1:3: cannot assign to 1" {
+		t.Errorf("wrong error. got=%q", errors[0])
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("Body has not 1 statement. got=%d", len(stmt.Body.Statements))
+	}
+
+	body, ok := stmt.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Body.Statements[0] is not ast.ExpressionStatement. got=%T",
+			stmt.Body.Statements[0])
+	}
+
+	testIdentifier(t, body.Expression, "x")
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (x in xs) { break; continue; };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testIdentifier(t, stmt.Variable, "x") {
+		return
+	}
+
+	if !testIdentifier(t, stmt.Iterable, "xs") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("Body has not 2 statements. got=%d", len(stmt.Body.Statements))
+	}
+
+	if _, ok := stmt.Body.Statements[0].(*ast.BreakStatement); !ok {
+		t.Errorf("Body.Statements[0] is not ast.BreakStatement. got=%T",
+			stmt.Body.Statements[0])
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Errorf("Body.Statements[1] is not ast.ContinueStatement. got=%T",
+			stmt.Body.Statements[1])
+	}
+
+	if program.String() != "for(x in xs) break;continue;" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		catch    bool
+		finally  bool
+		expected string
+	}{
+		{"try { x } catch (e) { e }", true, false, "try x catch(e) e"},
+		{"try { x } finally { y }", false, true, "try x finally y"},
+		{"try { x } catch (e) { e } finally { y };", true, true, "try x catch(e) e finally y"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d",
+				len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.TryStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.TryStatement. got=%T",
+				program.Statements[0])
+		}
+
+		if (stmt.Catch != nil) != tt.catch {
+			t.Errorf("stmt.Catch wrong. want catch=%t, got=%v", tt.catch, stmt.Catch)
+		}
+
+		if tt.catch && !testIdentifier(t, stmt.CatchParameter, "e") {
+			return
+		}
+
+		if (stmt.Finally != nil) != tt.finally {
+			t.Errorf("stmt.Finally wrong. want finally=%t, got=%v", tt.finally, stmt.Finally)
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestTryWithoutCatchOrFinally(t *testing.T) {
+	l := lexer.New("try { x }; y;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. want=1, got=%d (%v)", len(errors), errors)
+	}
+
+	expected := "1:10: expected next token to be CATCH, got ; instead"
+	if errors[0] != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, errors[0])
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	l := lexer.New(`throw "boom";`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ThrowStatement. got=%T",
+			program.Statements[0])
+	}
+
+	literal, ok := stmt.Value.(*ast.StringLiteral)
+	if !ok || literal.Value != "boom" {
+		t.Errorf("stmt.Value is not \"boom\". got=%s", stmt.Value)
+	}
+}
+
+func TestImportStatement(t *testing.T) {
+	l := lexer.New(`import "lib/strings.monkey" as strings;`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ImportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ImportStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if stmt.Path.Value != "lib/strings.monkey" {
+		t.Errorf("stmt.Path.Value not %q. got=%q", "lib/strings.monkey", stmt.Path.Value)
+	}
+	if stmt.Alias.Value != "strings" {
+		t.Errorf("stmt.Alias.Value not %q. got=%q", "strings", stmt.Alias.Value)
+	}
+}
+
+func TestExportStatement(t *testing.T) {
+	l := lexer.New("export let x = 5;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExportStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testLetStatement(t, stmt.Statement, "x") {
+		return
+	}
+	testLiteralExpression(t, stmt.Statement.Value, 5)
+}
+
+func TestImportExportErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import strings as s;`, "1:8: expected next token to be STRING, got IDENTIFIER instead"},
+		{`import "strings" s;`, "1:18: expected next token to be AS, got IDENTIFIER instead"},
+		{`export 5;`, "1:8: expected next token to be LET, got INT instead"},
+		{`if (true) { export let x = 1; }`, "1:13: export is only allowed at the top level"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected an error", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
 func testIdentifier(t *testing.T, expr ast.Expression, value string) bool {
 	identifier, ok := expr.(*ast.Identifier)
 	if !ok {
@@ -1010,3 +1350,243 @@ func testBooleanLiteral(t *testing.T,
 
 	return true
 }
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x 5;", "1:7: expected next token to be =, got INT instead"},
+		{"let x = 5;\nlet = 10;", "2:5: expected next token to be IDENTIFIER, got = instead"},
+		{"add(1,\n   2", "2:5: expected next token to be ), got EOF instead"},
+		{"let x = 1;\n  /* a /* b */\nlet y = 2;", "2:3: unterminated block comment, missing */"},
+		{"let x = /* a", "1:9: unterminated block comment, missing */"},
+	}
+
+	for _, tt := range tests {
+		lexer := lexer.New(tt.input)
+		parser := New(lexer)
+		parser.ParseProgram()
+
+		errors := parser.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q, got none", tt.input)
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("wrong first error. expected=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestBlockComments(t *testing.T) {
+	input := `/* header
+   /* nested */
+*/
+let x = /* one */ 1; // trailing
+let y = 2 /* two */;`
+
+	parser := New(lexer.NewWithMode(input, "", lexer.ScanComments))
+	program := parser.ParseProgram()
+	checkParserErrors(t, parser)
+
+	if program.String() != "let x = 1;let y = 2;" {
+		t.Errorf("wrong program. got=%q", program.String())
+	}
+	if len(program.Comments) != 4 {
+		t.Fatalf("wrong number of comments. expected=4, got=%d", len(program.Comments))
+	}
+	if program.Comments[0].Trailing || !program.Comments[1].Trailing {
+		t.Errorf("wrong trailing comments. got=%t, %t", program.Comments[0].Trailing, program.Comments[1].Trailing)
+	}
+
+	parser = New(lexer.New("let x = 1; /* a"))
+	parser.ParseProgram()
+	if len(parser.Errors()) != 1 {
+		t.Errorf("expected 1 error for an unterminated comment. got=%q", parser.Errors())
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+	a + b;
+};
+add(1, 2);`
+
+	lexer := lexer.NewWithFilename(input, "add.monkey")
+	parser := New(lexer)
+	program := parser.ParseProgram()
+	checkParserErrors(t, parser)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	fn := let.Value.(*ast.FunctionLiteral)
+	body := fn.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression
+
+	tests := []struct {
+		node     ast.Node
+		expected string
+	}{
+		{let, "add.monkey:1:1"},
+		{fn, "add.monkey:1:11"},
+		{body, "add.monkey:2:2"},
+		{body.Expression, "add.monkey:2:4"},
+		{call, "add.monkey:4:4"},
+	}
+
+	for i, tt := range tests {
+		if tt.node.Pos().String() != tt.expected {
+			t.Errorf("tests[%d] - wrong position. expected=%q, got=%q",
+				i, tt.expected, tt.node.Pos().String())
+		}
+	}
+}
+
+func TestTrailingCommas(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2,]", "[1, 2]"},
+		{"add(\n1,\n2,\n)", "add(1, 2)"},
+		{"{\"a\": 1,}", "{a:1}"},
+	}
+
+	for _, tt := range tests {
+		parser := New(lexer.New(tt.input))
+		program := parser.ParseProgram()
+		checkParserErrors(t, parser)
+
+		if program.String() != tt.expected {
+			t.Errorf("%q: wrong program. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = [1, // one
+    2,
+];
+// last`
+
+	parser := New(lexer.NewWithMode(input, "", lexer.ScanComments))
+	program := parser.ParseProgram()
+	checkParserErrors(t, parser)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	array := program.Statements[0].(*ast.LetStatement).Value.(*ast.ArrayLiteral)
+	if array.End.Line != 4 || array.End.Column != 1 {
+		t.Errorf("wrong end of array. expected=4:1, got=%d:%d", array.End.Line, array.End.Column)
+	}
+
+	tests := []struct {
+		literal  string
+		line     int
+		trailing bool
+	}{
+		{"// leading", 1, false},
+		{"// one", 2, true},
+		{"// last", 5, false},
+	}
+
+	if len(program.Comments) != len(tests) {
+		t.Fatalf("wrong number of comments. expected=%d, got=%d", len(tests), len(program.Comments))
+	}
+	for i, tt := range tests {
+		comment := program.Comments[i]
+		if comment.Token.Literal != tt.literal || comment.Pos().Line != tt.line || comment.Trailing != tt.trailing {
+			t.Errorf("comments[%d] wrong. expected=%q on line %d (trailing %t), got=%q on line %d (trailing %t)",
+				i, tt.literal, tt.line, tt.trailing, comment.Token.Literal, comment.Pos().Line, comment.Trailing)
+		}
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"let = 5; let y = 10; let 3;",
+			[]string{
+				"1:5: expected next token to be IDENTIFIER, got = instead",
+				"1:26: expected next token to be IDENTIFIER, got INT instead",
+			},
+		},
+		{
+			"let x = (1 + ;\nlet y = 2;\nadd(1, 2",
+			[]string{
+				"1:14: no prefix parse function for ; found",
+				"3:9: expected next token to be ), got EOF instead",
+			},
+		},
+		{
+			`let f = fn(x) {
+	let = x;
+	x +;
+	return x;
+};
+let h = {"a": 1 "b": 2};
+let z = );`,
+			[]string{
+				"2:6: expected next token to be IDENTIFIER, got = instead",
+				"3:5: no prefix parse function for ; found",
+				"6:17: expected next token to be ,, got STRING instead",
+				"7:9: no prefix parse function for ) found",
+			},
+		},
+		{
+			"if (x) { let y = } let z = 1;",
+			[]string{
+				"1:18: no prefix parse function for } found",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		lexer := lexer.New(tt.input)
+		parser := New(lexer)
+		parser.ParseProgram()
+
+		errors := parser.Errors()
+		if len(errors) != len(tt.expected) {
+			t.Errorf("wrong number of errors for %q.\nwant=%q\ngot=%q",
+				tt.input, tt.expected, errors)
+			continue
+		}
+
+		for i, expected := range tt.expected {
+			if errors[i] != expected {
+				t.Errorf("wrong error %d. want=%q, got=%q", i, expected, errors[i])
+			}
+		}
+	}
+}
+
+func TestParseErrorDetails(t *testing.T) {
+	lexer := lexer.NewWithFilename("let x 5;", "bad.monkey")
+	parser := New(lexer)
+	parser.ParseProgram()
+
+	errors := parser.ParseErrors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error, got=%d", len(errors))
+	}
+
+	err := errors[0]
+	if err.Expected != token.ASSIGN {
+		t.Errorf("wrong expected token. want=%q, got=%q", token.ASSIGN, err.Expected)
+	}
+	if err.Found.Type != token.INT || err.Found.Literal != "5" {
+		t.Errorf("wrong found token. got=%+v", err.Found)
+	}
+	if err.Pos.String() != "bad.monkey:1:7" {
+		t.Errorf("wrong position. want=%q, got=%q", "bad.monkey:1:7", err.Pos)
+	}
+	if err.Error() != "bad.monkey:1:7: expected next token to be =, got INT instead" {
+		t.Errorf("wrong error string. got=%q", err.Error())
+	}
+}
@@ -0,0 +1,76 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// maxHistory is the number of lines kept in the history file.
+const maxHistory = 1000
+
+// history keeps the lines entered in the REPL in a file, one per line.
+type history struct {
+	path  string
+	lines []string
+}
+
+// loadHistory reads the history kept in path, which is created on the first
+// line added. It returns nil if path is empty.
+func loadHistory(path string) (*history, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	h := &history{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h.lines = append(h.lines, scanner.Text())
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[len(h.lines)-maxHistory:]
+		err = h.save()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// add appends line to the history and its file.
+func (h *history) add(line string) error {
+	h.lines = append(h.lines, line)
+
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(line + "\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// save replaces the file with the lines of h.
+func (h *history) save() error {
+	contents := strings.Join(h.lines, "\n") + "\n"
+	return os.WriteFile(h.path, []byte(contents), 0o600)
+}
@@ -4,58 +4,362 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"monkey/ast"
 	"monkey/compiler"
+	"monkey/disasm"
+	"monkey/evaluator"
 	"monkey/lexer"
+	"monkey/module"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/token"
 	"monkey/vm"
+	"os"
+	"strings"
+	"time"
 )
 
-const PROMPT = "🐒 ➤ "
+const (
+	PROMPT = "🐒 ➤ "
+	// CONTINUATION_PROMPT asks for more lines of an unfinished input.
+	CONTINUATION_PROMPT = "   … "
+)
+
+// Engines the REPL can run the input with.
+const (
+	EngineVM   = "vm"
+	EngineEval = "eval"
+)
+
+type Config struct {
+	// Engine is EngineVM or EngineEval, EngineVM if empty.
+	Engine string
+	// HistoryFile keeps the entered lines across sessions, no history is
+	// kept if it is empty.
+	HistoryFile string
+}
 
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+	StartWithConfig(in, out, Config{})
+}
 
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalSize)
-	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+// StartWithConfig reads input from in until it is balanced, runs it and
+// prints the result to out. Lines starting with a colon are meta-commands,
+// see :help.
+func StartWithConfig(in io.Reader, out io.Writer, config Config) {
+	s := &session{
+		out: out,
+		// programs print to out as well, in between the results
+		streams: object.IO{Stdout: out, Stderr: out, Stdin: in},
+		engine:  EngineVM,
+	}
+	if config.Engine != "" {
+		s.engine = config.Engine
+	}
+	s.reset()
+
+	history, err := loadHistory(config.HistoryFile)
+	if err != nil {
+		fmt.Fprintf(out, "🙈 Woops! Loading the history failed:\n %s\n", err)
 	}
+	s.history = history
 
+	scanner := bufio.NewScanner(in)
+	var input strings.Builder
 	for {
-		fmt.Fprint(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		if input.Len() == 0 {
+			fmt.Fprint(out, PROMPT)
+		} else {
+			fmt.Fprint(out, CONTINUATION_PROMPT)
+		}
+		if !scanner.Scan() {
 			return
 		}
-
 		line := scanner.Text()
-		lexer := lexer.New(line)
-		parser := parser.New(lexer)
-		program := parser.ParseProgram()
+		s.record(line)
+
+		if input.Len() == 0 {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if strings.HasPrefix(line, ":") {
+				if s.command(line) {
+					return
+				}
+				continue
+			}
+		}
 
-		if len(parser.Errors()) != 0 {
-			printParseErrors(out, parser.Errors())
+		input.WriteString(line)
+		input.WriteString("\n")
+		// an empty line ends unbalanced input, which fails to parse then
+		if strings.TrimSpace(line) != "" && unbalanced(input.String()) {
 			continue
 		}
 
-		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
+		s.run(input.String(), "")
+		input.Reset()
+	}
+}
+
+// unbalanced reports whether input opens more parens, braces or brackets
+// than it closes, or ends in an unterminated /* comment.
+func unbalanced(input string) bool {
+	depth := 0
+	l := lexer.New(input)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		case token.ILLEGAL:
+			if strings.HasPrefix(tok.Literal, "/*") {
+				return true
+			}
+		}
+	}
+	return depth > 0
+}
+
+// session holds the state of the REPL, which lasts across inputs until it
+// is reset.
+type session struct {
+	out     io.Writer
+	streams object.IO
+	engine  string
+	history *history
+
+	showAST      bool
+	showBytecode bool
+	timing       bool
+
+	// state of the vm engine
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+
+	// state of the eval engine
+	env *object.Environment
+}
+
+func (s *session) reset() {
+	s.symbolTable = compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		s.symbolTable.DefineBuiltin(i, v.Name)
+	}
+	s.constants = []object.Object{}
+	s.globals = make([]object.Object, vm.GlobalSize)
+	s.globalNames = nil
+
+	s.env = object.NewEnvironment()
+	s.env.SetIO(s.streams)
+	s.env.Modules().SearchPath = module.SearchPathFromEnv()
+}
+
+func (s *session) record(line string) {
+	if s.history == nil || strings.TrimSpace(line) == "" {
+		return
+	}
+	err := s.history.add(line)
+	if err != nil {
+		fmt.Fprintf(s.out, "🙈 Woops! Saving the history failed:\n %s\n", err)
+		s.history = nil
+	}
+}
+
+// run parses and runs input, filename is used for positions and relative
+// imports.
+func (s *session) run(input, filename string) {
+	parser := parser.New(lexer.NewWithFilename(input, filename))
+	program := parser.ParseProgram()
+	if len(parser.Errors()) != 0 {
+		printParseErrors(s.out, parser.Errors())
+		return
+	}
+
+	if s.showAST {
+		printAST(s.out, program)
+	}
+
+	start := time.Now()
+	var result object.Object
+	if s.engine == EngineEval {
+		result = s.eval(program)
+	} else {
+		result = s.runVM(program)
+	}
+	if result == nil {
+		return
+	}
+
+	io.WriteString(s.out, result.Inspect())
+	io.WriteString(s.out, "\n")
+	if s.timing {
+		fmt.Fprintf(s.out, "(%s)\n", time.Since(start))
+	}
+}
+
+func (s *session) runVM(program *ast.Program) object.Object {
+	comp := compiler.NewWithState(s.symbolTable, s.constants)
+	comp.SetSearchPath(module.SearchPathFromEnv())
+	comp.SetOptimizationLevel(compiler.OptimizeBasic)
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintf(s.out, "🙈 Woops! Compilation failed:\n %s\n", err)
+		return nil
+	}
+
+	bytecode := comp.ByteCode()
+	// functions defined by earlier inputs refer to their constants
+	s.constants = bytecode.Constants
+	s.globalNames = bytecode.GlobalNames
+
+	if s.showBytecode {
+		err := disasm.Disassemble(s.out, bytecode)
 		if err != nil {
-			fmt.Fprintf(out, "🙈 Woops! Compilation failed:\n %s\n", err)
-			continue
+			fmt.Fprintf(s.out, "🙈 Woops! Disassembling failed:\n %s\n", err)
 		}
+	}
+
+	machine := vm.NewWithGlobalsStore(bytecode, s.globals)
+	machine.SetIO(s.streams)
+	err = machine.Run()
+	if err != nil {
+		fmt.Fprintf(s.out, "🙈 Woops! Executing bytecode failed:\n %s\n", err)
+		if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+			io.WriteString(s.out, runtimeErr.StackTrace())
+		}
+		return nil
+	}
+
+	return machine.LastPoppedStackElement()
+}
+
+func (s *session) eval(program *ast.Program) object.Object {
+	result := evaluator.Eval(program, s.env)
+	if err, ok := result.(*object.Error); ok {
+		fmt.Fprintf(s.out, "🙈 Woops! Evaluation failed:\n %s\n", err.Message)
+		io.WriteString(s.out, evaluator.NewRuntimeError(err).StackTrace())
+		return nil
+	}
+	// nil for let statements, which have no value
+	return result
+}
+
+// command runs the meta-command line and reports whether the REPL should
+// quit.
+func (s *session) command(line string) bool {
+	name, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	arg = strings.TrimSpace(arg)
 
-		machine := vm.NewWithGlobalsStore(comp.ByteCode(), globals)
-		err = machine.Run()
+	switch name {
+	case ":help":
+		io.WriteString(s.out, help)
+	case ":quit", ":exit":
+		return true
+	case ":ast":
+		s.showAST = !s.showAST
+		s.printToggle("ast", s.showAST)
+	case ":bytecode":
+		s.showBytecode = !s.showBytecode
+		s.printToggle("bytecode", s.showBytecode)
+		if s.showBytecode && s.engine != EngineVM {
+			fmt.Fprintf(s.out, "bytecode is only shown by the %s engine\n", EngineVM)
+		}
+	case ":time":
+		s.timing = !s.timing
+		s.printToggle("time", s.timing)
+	case ":globals":
+		s.printGlobals()
+	case ":load":
+		if arg == "" {
+			io.WriteString(s.out, "usage: :load file\n")
+			break
+		}
+		contents, err := os.ReadFile(arg)
 		if err != nil {
-			fmt.Fprintf(out, "🙈 Woops! Executing bytecode failed:\n %s\n", err)
+			fmt.Fprintf(s.out, "🙈 Woops! Loading failed:\n %s\n", err)
+			break
+		}
+		s.run(string(contents), arg)
+	case ":reset":
+		s.reset()
+		io.WriteString(s.out, "session reset\n")
+	case ":engine":
+		switch arg {
+		case "":
+			fmt.Fprintf(s.out, "engine %s\n", s.engine)
+		case EngineVM, EngineEval:
+			// the engines can't share their state
+			s.engine = arg
+			s.reset()
+			fmt.Fprintf(s.out, "engine %s, session reset\n", s.engine)
+		default:
+			fmt.Fprintf(s.out, "unknown engine %q, want %s or %s\n", arg, EngineVM, EngineEval)
+		}
+	case ":history":
+		if s.history == nil {
+			io.WriteString(s.out, "no history\n")
+			break
+		}
+		for i, line := range s.history.lines {
+			fmt.Fprintf(s.out, "%5d  %s\n", i+1, line)
+		}
+	default:
+		fmt.Fprintf(s.out, "unknown command %s, :help lists the commands\n", name)
+	}
+	return false
+}
+
+const help = `Input is run once its parens, braces and brackets are balanced, an empty
+line runs it right away. Commands:
+  :ast              toggle printing the AST of the input
+  :bytecode         toggle printing the bytecode of the input
+  :time             toggle printing how long the input ran
+  :globals          list the global variables
+  :load file        run the file
+  :reset            forget all variables
+  :engine vm|eval   run the input with the VM or the evaluator and reset
+  :history          list the lines entered
+  :quit             leave the REPL
+`
+
+func (s *session) printToggle(name string, on bool) {
+	state := "off"
+	if on {
+		state = "on"
+	}
+	fmt.Fprintf(s.out, "%s %s\n", name, state)
+}
+
+func (s *session) printGlobals() {
+	if s.engine == EngineEval {
+		for _, name := range s.env.Names() {
+			value, _ := s.env.Get(name)
+			fmt.Fprintf(s.out, "%s = %s\n", name, value.Inspect())
+		}
+		return
+	}
+
+	for i, name := range s.globalNames {
+		// hidden globals, like the exports of imported modules
+		if strings.HasPrefix(name, "$") {
+			continue
+		}
+		value := s.globals[i]
+		if value == nil {
+			// the definition failed at runtime
 			continue
 		}
+		fmt.Fprintf(s.out, "%s = %s\n", name, value.Inspect())
+	}
+}
 
-		lastPopped := machine.LastPoppedStackElement()
-		io.WriteString(out, lastPopped.Inspect())
+func printAST(out io.Writer, program *ast.Program) {
+	for _, stmt := range program.Statements {
+		io.WriteString(out, stmt.String())
 		io.WriteString(out, "\n")
 	}
 }
@@ -0,0 +1,148 @@
+package repl
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnbalanced(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2", false},
+		{"let f = fn(x) {", true},
+		{"let f = fn(x) {\nx\n}", false},
+		{"[1, [2,", true},
+		{"{\"a\": (1", true},
+		{`"{"`, false},
+		{"}", false},
+		{"1 /* a /* b */", true},
+		{"1 /* a /* b */ c */", false},
+		{"1 // (", false},
+	}
+
+	for _, tt := range tests {
+		if got := unbalanced(tt.input); got != tt.expected {
+			t.Errorf("unbalanced(%q) wrong. want=%t, got=%t", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.monkey")
+	err := os.WriteFile(script, []byte("let loaded = fn(x) {\n  x + 1\n};\nloaded(1)"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		engine   string
+		input    string
+		expected []string
+	}{
+		{"multi-line", EngineVM,
+			"let f = fn(x) {\n  x * 2\n};\nf(\n4\n)\n",
+			[]string{"8\n"}},
+		{"multi-line eval", EngineEval,
+			"let h = {\n\"a\": [1,\n2]\n};\nh[\"a\"]\n",
+			[]string{"[1, 2]\n"}},
+		{"empty line ends input", EngineVM,
+			"let a = [1,\n\na\n",
+			[]string{"Parser errors occured"}},
+		{"earlier functions keep their constants", EngineVM,
+			"let f = fn() { \"first\" };\nlet g = fn() { \"second\" };\nf()\n",
+			[]string{"first\n"}},
+		{"puts", EngineVM,
+			"puts(\"out\")\n",
+			[]string{"out\nnull\n"}},
+		{"globals", EngineVM,
+			"let a = 1;\nlet b = [a];\n:globals\n",
+			[]string{"a = 1\nb = [1]\n"}},
+		{"globals eval", EngineEval,
+			"let a = 1;\nlet b = [a];\n:globals\n",
+			[]string{"a = 1\nb = [1]\n"}},
+		{"reset", EngineVM,
+			"let a = 1;\n:reset\na\n",
+			[]string{"session reset\n", "undefined variable a"}},
+		{"engine", EngineVM,
+			":engine eval\nputs(1)\n:engine\n:engine js\n",
+			[]string{"engine eval, session reset\n", "engine eval\n", `unknown engine "js"`}},
+		{"ast", EngineVM,
+			":ast\n-1 + 2\n",
+			[]string{"ast on\n", "((-1) + 2)\n1\n"}},
+		{"bytecode", EngineVM,
+			":bytecode\n1 + 2\n",
+			[]string{"bytecode on\n", "OpConstant", "3\n"}},
+		{"time", EngineEval,
+			":time\n1\n",
+			[]string{"time on\n", "1\n("}},
+		{"load", EngineVM,
+			fmt.Sprintf(":load %s\nloaded(2)\n:load\n:load %s\n", script, filepath.Join(dir, "missing")),
+			[]string{"2\n", "3\n", "usage: :load file\n", "Loading failed"}},
+		{"quit", EngineVM,
+			":quit\n1\n",
+			[]string{}},
+		{"unknown command", EngineVM,
+			":foo\n",
+			[]string{"unknown command :foo"}},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		StartWithConfig(strings.NewReader(tt.input), &out, Config{Engine: tt.engine})
+		output := out.String()
+
+		for _, expected := range tt.expected {
+			if !strings.Contains(output, expected) {
+				t.Errorf("%s: output does not contain %q. got=%q", tt.name, expected, output)
+			}
+		}
+		if tt.name == "quit" && strings.Contains(output, "1\n") {
+			t.Errorf("%s: input after :quit was run. got=%q", tt.name, output)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+
+	var out bytes.Buffer
+	StartWithConfig(strings.NewReader("let a = fn() {\n1 };\n\n:globals\n"), &out, Config{HistoryFile: path})
+	StartWithConfig(strings.NewReader("a()\n:history\n"), &out, Config{HistoryFile: path})
+
+	expected := "    1  let a = fn() {\n    2  1 };\n    3  :globals\n    4  a()\n    5  :history\n"
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("history not listed. want=%q, got=%q", expected, out.String())
+	}
+
+	lines := make([]string, maxHistory+10)
+	for i := range lines {
+		lines[i] = fmt.Sprint(i)
+	}
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := loadHistory(path)
+	if err != nil {
+		t.Fatalf("loadHistory failed: %s", err)
+	}
+	if len(h.lines) != maxHistory || h.lines[0] != "10" {
+		t.Fatalf("history not trimmed. got %d lines starting with %q", len(h.lines), h.lines[0])
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(contents), "10\n11\n") {
+		t.Errorf("history file not trimmed. got=%q", contents[:10])
+	}
+}
@@ -0,0 +1,180 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"monkey/object"
+)
+
+// Kinds of runtime errors, a *RuntimeError of one of these kinds matches it
+// with errors.Is.
+var (
+	ErrDivisionByZero  = errors.New("division by zero")
+	ErrInvalidIndex    = errors.New("invalid index")
+	ErrIndexOutOfRange = errors.New("index out of range")
+	ErrStackOverflow   = errors.New("stack overflow")
+	ErrStackUnderflow  = errors.New("stack underflow")
+	ErrFrameOverflow   = errors.New("maximum call depth exceeded")
+)
+
+// kindError is an error of one of the kinds above with a message describing
+// the concrete failure.
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (ke *kindError) Error() string { return ke.message }
+func (ke *kindError) Unwrap() error { return ke.kind }
+
+func newKindError(kind error, format string, a ...any) error {
+	return &kindError{kind: kind, message: fmt.Sprintf(format, a...)}
+}
+
+// thrownError carries an exception raised by a throw statement or a failing
+// builtin.
+type thrownError struct {
+	exception *object.Exception
+}
+
+func (te *thrownError) Error() string { return te.exception.Message }
+
+// callbackError is the runtime error of a callback made by a builtin. It is
+// raised once the builtin returned and keeps the trace of the callback.
+type callbackError struct {
+	err   error
+	trace []string
+}
+
+func (ce *callbackError) Error() string { return ce.err.Error() }
+func (ce *callbackError) Unwrap() error { return ce.err }
+
+// recoveredError turns the value of a recovered panic into an error. The VM
+// only panics on corrupt bytecode, a script must never crash the host.
+func recoveredError(r any) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", r)
+}
+
+type TraceEntry struct {
+	Function string
+	Filename string
+	Line     int
+}
+
+func (te TraceEntry) String() string {
+	location := te.Filename
+	if location == "" {
+		location = "<input>"
+	}
+	if te.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, te.Line)
+	}
+	return fmt.Sprintf("%s (%s)", te.Function, location)
+}
+
+// RuntimeError is returned by Run whenever the execution of the bytecode
+// fails. Trace holds the Monkey call stack at the time of the failure with
+// the innermost call first.
+type RuntimeError struct {
+	Message string
+	Trace   []TraceEntry
+	Err     error
+}
+
+func (re *RuntimeError) Error() string { return re.Message }
+func (re *RuntimeError) Unwrap() error { return re.Err }
+
+func (re *RuntimeError) StackTrace() string {
+	var out bytes.Buffer
+
+	for _, entry := range re.Trace {
+		out.WriteString("\tat " + entry.String() + "\n")
+	}
+
+	return out.String()
+}
+
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	return &RuntimeError{
+		Message: err.Error(),
+		Trace:   vm.stackTrace(),
+		Err:     err,
+	}
+}
+
+// handleError continues execution at the innermost exception handler with
+// err as exception on the stack. Only the handlers above handlerBase are
+// considered, it reports false if there is none.
+func (vm *VM) handleError(err error, handlerBase int) bool {
+	if len(vm.handlers) <= handlerBase {
+		return false
+	}
+
+	exception := vm.exception(err)
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.framesIndex = h.framesIndex
+	vm.sp = h.sp
+	vm.currentFrame().ip = h.catchIP - 1
+
+	return vm.push(exception.Caught()) == nil
+}
+
+func (vm *VM) exception(err error) *object.Exception {
+	switch err := err.(type) {
+	case *thrownError:
+		return err.exception
+	case *callbackError:
+		return &object.Exception{Message: err.Error(), Trace: err.trace}
+	}
+	return &object.Exception{Message: err.Error(), Trace: vm.traceStrings()}
+}
+
+// throw raises value as exception, recording the current stack trace unless
+// value is an exception which already has one.
+func (vm *VM) throw(value object.Object) error {
+	exception := object.NewException(value)
+	if exception.Trace == nil {
+		exception.Trace = vm.traceStrings()
+	}
+	return &thrownError{exception: exception}
+}
+
+func (vm *VM) traceStrings() []string {
+	return traceStrings(vm.stackTrace())
+}
+
+func traceStrings(trace []TraceEntry) []string {
+	entries := make([]string, len(trace))
+	for i, entry := range trace {
+		entries[i] = entry.String()
+	}
+	return entries
+}
+
+func (vm *VM) stackTrace() []TraceEntry {
+	trace := make([]TraceEntry, 0, vm.framesIndex)
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		fn := frame.cl.Fn
+
+		entry := TraceEntry{Function: fn.Name, Filename: fn.Filename}
+		if entry.Function == "" {
+			entry.Function = "<anonymous>"
+		}
+		if line, ok := fn.Lines.Lookup(frame.ip); ok {
+			entry.Line = line
+		}
+
+		trace = append(trace, entry)
+	}
+
+	return trace
+}
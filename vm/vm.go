@@ -1,9 +1,12 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"monkey/code"
 	"monkey/compiler"
+	"monkey/limit"
 	"monkey/object"
 )
 
@@ -26,30 +29,67 @@ type VM struct {
 	sp    int // Always points to the next value. Top of stack is stack[sp-1]
 
 	globals []object.Object
+	// globalNames names the globals of the program, for error messages
+	globalNames []string
 
 	frames      []*Frame
 	framesIndex int
+
+	handlers []handler
+
+	builtins       []*object.Builtin
+	builtinContext *object.BuiltinContext
+	// callbackErr is the error of a failed call made by the running builtin
+	callbackErr error
+	// reserved is the size the running builtin reserved for its objects
+	reserved int64
+
+	limits limit.Limits
+	// budget of the running program, nil while the VM is idle
+	budget *limit.Budget
+}
+
+// handler is an exception handler installed by OpSetupTry. It restores the
+// frame and the stack of the try statement and continues at catchIP.
+type handler struct {
+	catchIP     int
+	framesIndex int
+	sp          int
 }
 
 func New(bytecode *compiler.ByteCode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Name:         "<main>",
+		Filename:     bytecode.Filename,
+		Lines:        bytecode.Lines,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
 
-	return &VM{
+	vm := &VM{
 		constants: bytecode.Constants,
 
 		stack: make([]object.Object, StackSize),
 		sp:    0,
 
-		globals: make([]object.Object, GlobalSize),
+		globals:     make([]object.Object, GlobalSize),
+		globalNames: bytecode.GlobalNames,
 
 		frames:      frames,
 		framesIndex: 1,
+
+		builtins: DefaultBuiltins(),
 	}
+	vm.builtinContext = &object.BuiltinContext{
+		Call:     vm.callFromBuiltin,
+		Allocate: vm.allocateFromBuiltin,
+		IO:       object.StandardIO(),
+	}
+	return vm
 }
 
 func NewWithGlobalsStore(bytecode *compiler.ByteCode, s []object.Object) *VM {
@@ -58,16 +98,169 @@ func NewWithGlobalsStore(bytecode *compiler.ByteCode, s []object.Object) *VM {
 	return vm
 }
 
+// NewWithState returns a VM using the globals store s and builtins, whose
+// indices have to match the builtins defined in the compiler's symbol table.
+func NewWithState(
+	bytecode *compiler.ByteCode,
+	s []object.Object,
+	builtins []*object.Builtin,
+) *VM {
+	vm := NewWithGlobalsStore(bytecode, s)
+	vm.builtins = builtins
+	return vm
+}
+
+// DefaultBuiltins returns the builtins of object.Builtins in the order the
+// compiler defines them.
+func DefaultBuiltins() []*object.Builtin {
+	builtins := make([]*object.Builtin, len(object.Builtins))
+	for i, def := range object.Builtins {
+		builtins[i] = def.Builtin
+	}
+	return builtins
+}
+
 func (vm *VM) LastPoppedStackElement() object.Object {
 	return vm.stack[vm.sp]
 }
 
+// SetIO sets the streams builtins use, the default is object.StandardIO.
+func (vm *VM) SetIO(io object.IO) {
+	vm.builtinContext.IO = io
+}
+
+// SetLimits sets the limits of the programs run afterwards. Every call of
+// Run or Call starts counting anew.
+func (vm *VM) SetLimits(limits limit.Limits) {
+	vm.limits = limits
+}
+
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext runs the program like Run. Once ctx is done or the program
+// exceeds its limits, it stops the program with a *limit.StopError, which
+// try statements can't catch.
+func (vm *VM) RunContext(ctx context.Context) error {
+	vm.budget = limit.NewBudget(ctx, vm.limits)
+	defer func() { vm.budget = nil }()
+
+	return vm.runUntil(0)
+}
+
+// Call calls fn, a closure or a builtin, with args and returns the result.
+// It can be used once Run returned, for example with a closure the program
+// stored in a global, as well as from a builtin while Run is executing.
+// A VM must not be used from multiple goroutines at the same time.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	return vm.CallContext(context.Background(), fn, args...)
+}
+
+// CallContext calls fn like Call and stops it like RunContext. Calls made
+// while the VM is running count against the limits of the running program
+// instead, which ctx can't stop.
+func (vm *VM) CallContext(
+	ctx context.Context,
+	fn object.Object,
+	args ...object.Object,
+) (result object.Object, err error) {
+	if vm.budget == nil {
+		vm.budget = limit.NewBudget(ctx, vm.limits)
+		defer func() { vm.budget = nil }()
+	}
+
+	sp, framesIndex, handlers := vm.sp, vm.framesIndex, len(vm.handlers)
+	defer func() {
+		if err != nil {
+			vm.sp, vm.framesIndex = sp, framesIndex
+			vm.handlers = vm.handlers[:handlers]
+		}
+	}()
+
+	for _, o := range append([]object.Object{fn}, args...) {
+		err = vm.push(o)
+		if err != nil {
+			return nil, vm.newRuntimeError(err)
+		}
+	}
+
+	err = vm.executeCall(len(args))
+	if err != nil {
+		return nil, vm.newRuntimeError(err)
+	}
+
+	if vm.framesIndex > framesIndex {
+		err = vm.runUntil(framesIndex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result = vm.pop()
+	vm.sp = sp
+	return result, nil
+}
+
+// callFromBuiltin implements BuiltinContext.Call.
+func (vm *VM) callFromBuiltin(fn object.Object, args ...object.Object) object.Object {
+	result, err := vm.Call(fn, args...)
+	if err != nil {
+		var runtimeErr *RuntimeError
+		if errors.As(err, &runtimeErr) {
+			err = runtimeErr.Err
+			if _, ok := err.(*thrownError); !ok && !limit.IsStop(err) {
+				err = &callbackError{err: err, trace: traceStrings(runtimeErr.Trace)}
+			}
+		}
+		vm.callbackErr = err
+		return &object.Error{Message: err.Error()}
+	}
+	return result
+}
+
+// allocateFromBuiltin implements BuiltinContext.Allocate.
+func (vm *VM) allocateFromBuiltin(size int64) error {
+	vm.reserved += size
+	return vm.budget.Allocate(size)
+}
+
+// runUntil runs the VM like run and passes errors to the exception handlers
+// installed since it was called.
+func (vm *VM) runUntil(base int) (err error) {
+	handlerBase := len(vm.handlers)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.newRuntimeError(recoveredError(r))
+		}
+	}()
+
+	for {
+		err = vm.run(base)
+		if err == nil {
+			return nil
+		}
+
+		if limit.IsStop(err) || !vm.handleError(err, handlerBase) {
+			return vm.newRuntimeError(err)
+		}
+	}
+}
+
+// run executes instructions until the main program ends or a return brings
+// the number of frames down to base.
+func (vm *VM) run(base int) error {
 	var ip int
 	var instr code.Instructions
 	var op code.OpCode
 
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		err := vm.budget.Step()
+		if err != nil {
+			return err
+		}
+
 		vm.currentFrame().ip++
 		ip = vm.currentFrame().ip
 		instr = vm.currentFrame().Instructions()
@@ -132,7 +325,12 @@ func (vm *VM) Run() error {
 			globalIndex := code.ReadUint16(instr[ip+1:])
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return undefinedError(vm.globalNames, int(globalIndex))
+			}
+
+			err := vm.push(global)
 			if err != nil {
 				return err
 			}
@@ -143,13 +341,35 @@ func (vm *VM) Run() error {
 
 			vm.globals[globalIndex] = vm.pop()
 
+		case code.OpImport:
+			globalIndex := code.ReadUint16(instr[ip+1:])
+			pos := int(code.ReadUint16(instr[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			// a module already loaded is not run again
+			if exports := vm.globals[globalIndex]; exports != nil {
+				err := vm.push(exports)
+				if err != nil {
+					return err
+				}
+				vm.currentFrame().ip = pos - 1
+			}
+
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(instr[ip+1:])
 			vm.currentFrame().ip += 1
 
 			frame := vm.currentFrame()
 
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			local := vm.stack[frame.basePointer+int(localIndex)]
+			if cell, ok := local.(*object.Cell); ok {
+				local = cell.Value
+			}
+			if local == nil {
+				return undefinedError(frame.cl.Fn.LocalNames, int(localIndex))
+			}
+
+			err := vm.push(local)
 			if err != nil {
 				return err
 			}
@@ -159,16 +379,41 @@ func (vm *VM) Run() error {
 			vm.currentFrame().ip += 1
 
 			frame := vm.currentFrame()
+			slot := frame.basePointer + int(localIndex)
 
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			if cell, ok := vm.stack[slot].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				vm.stack[slot] = vm.pop()
+			}
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(instr[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			slot := frame.basePointer + int(localIndex)
+
+			cell, ok := vm.stack[slot].(*object.Cell)
+			if !ok {
+				err := vm.budget.Allocate(1)
+				if err != nil {
+					return err
+				}
+				cell = &object.Cell{Value: vm.stack[slot]}
+				vm.stack[slot] = cell
+			}
+
+			err := vm.push(cell)
+			if err != nil {
+				return err
+			}
 
 		case code.OpGetBuiltin:
 			builtinIndex := code.ReadUint8(instr[ip+1:])
 			vm.currentFrame().ip += 1
 
-			definition := object.Builtins[builtinIndex]
-
-			err := vm.push(definition.Builtin)
+			err := vm.push(vm.builtins[builtinIndex])
 			if err != nil {
 				return err
 			}
@@ -177,6 +422,28 @@ func (vm *VM) Run() error {
 			freeIndex := code.ReadUint8(instr[ip+1:])
 			vm.currentFrame().ip += 1
 
+			currentClosure := vm.currentFrame().cl
+			cell := currentClosure.Free[freeIndex].(*object.Cell)
+			if cell.Value == nil {
+				return undefinedError(currentClosure.Fn.FreeNames, int(freeIndex))
+			}
+			err := vm.push(cell.Value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(instr[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			cell := currentClosure.Free[freeIndex].(*object.Cell)
+			cell.Value = vm.pop()
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(instr[ip+1:])
+			vm.currentFrame().ip += 1
+
 			currentClosure := vm.currentFrame().cl
 			err := vm.push(currentClosure.Free[freeIndex])
 			if err != nil {
@@ -197,7 +464,7 @@ func (vm *VM) Run() error {
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
 
-			err := vm.push(array)
+			err := vm.pushNew(array)
 			if err != nil {
 				return err
 			}
@@ -212,7 +479,7 @@ func (vm *VM) Run() error {
 			}
 			vm.sp = vm.sp - numElements
 
-			err = vm.push(hash)
+			err = vm.pushNew(hash)
 			if err != nil {
 				return err
 			}
@@ -235,6 +502,15 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(instr[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+
 		case code.OpClosure:
 			constIndex := code.ReadUint16(instr[ip+1:])
 			numFree := code.ReadUint8(instr[ip+3:])
@@ -245,9 +521,71 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpDup:
+			count := int(code.ReadUint8(instr[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			start := vm.sp - count
+			for i := 0; i < count; i++ {
+				err := vm.push(vm.stack[start+i])
+				if err != nil {
+					return err
+				}
+			}
+
+		case code.OpIter:
+			iterable := vm.pop()
+
+			iterator, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
+
+			err := vm.pushNew(iterator)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			err := vm.executeIterNext()
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetupTry:
+			catchIP := int(code.ReadUint16(instr[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			vm.handlers = append(vm.handlers, handler{
+				catchIP:     catchIP,
+				framesIndex: vm.framesIndex,
+				sp:          vm.sp,
+			})
+
+		case code.OpPopTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpThrow:
+			return vm.throw(vm.pop())
+
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 
+			if vm.framesIndex == 1 {
+				// returning from the main program ends it
+				return nil
+			}
+
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
 
@@ -256,6 +594,10 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+			if vm.framesIndex == base {
+				return nil
+			}
+
 		case code.OpReturn:
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
@@ -265,8 +607,17 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+			if vm.framesIndex == base {
+				return nil
+			}
+
 		case code.OpPop:
 			vm.pop()
+
+		default:
+			// bytecode of a newer compiler, which the format version
+			// should have rejected
+			return fmt.Errorf("unknown opcode %d", op)
 		}
 	}
 	return nil
@@ -274,7 +625,7 @@ func (vm *VM) Run() error {
 
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+		return ErrStackOverflow
 	}
 
 	vm.stack[vm.sp] = o
@@ -284,6 +635,10 @@ func (vm *VM) push(o object.Object) error {
 }
 
 func (vm *VM) pop() object.Object {
+	if vm.sp == 0 {
+		// recovered in Run
+		panic(ErrStackUnderflow)
+	}
 	o := vm.stack[vm.sp-1]
 	vm.sp--
 	return o
@@ -299,6 +654,8 @@ func (vm *VM) executeBinaryOperation(op code.OpCode) error {
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -325,12 +682,41 @@ func (vm *VM) executeBinaryIntegerOperation(
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return ErrDivisionByZero
+		}
 		result = leftValue / rightValue
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
 
-	return vm.push(&object.Integer{Value: result})
+	return vm.pushNew(&object.Integer{Value: result})
+}
+
+func (vm *VM) executeBinaryFloatOperation(
+	op code.OpCode,
+	left object.Object,
+	right object.Object,
+) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	var result float64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.pushNew(&object.Float{Value: result})
 }
 
 func (vm *VM) executeBinaryStringOperation(
@@ -345,7 +731,7 @@ func (vm *VM) executeBinaryStringOperation(
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
 
-	return vm.push(&object.String{Value: leftValue + rightValue})
+	return vm.pushNew(&object.String{Value: leftValue + rightValue})
 }
 
 func (vm *VM) executeComparison(op code.OpCode) error {
@@ -356,11 +742,19 @@ func (vm *VM) executeComparison(op code.OpCode) error {
 		return vm.executeIntegerComparison(op, left, right)
 	}
 
+	if isNumber(left) && isNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
+
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
+
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(left == right))
+		return vm.push(nativeBoolToBooleanObject(object.Equals(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(left != right))
+		return vm.push(nativeBoolToBooleanObject(!object.Equals(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d (%s %s)",
 			op, left.Type(), right.Type())
@@ -392,47 +786,132 @@ func (vm *VM) executeIntegerComparison(
 	}
 }
 
-func (vm *VM) executeBangOperator() error {
-	operand := vm.pop()
+func (vm *VM) executeFloatComparison(
+	op code.OpCode,
+	left, right object.Object,
+) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
 
-	switch operand {
-	case True:
-		return vm.push(False)
-	case False:
-		return vm.push(True)
-	case Null:
-		return vm.push(True)
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLesserThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLesserEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
 	default:
-		return vm.push(False)
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeStringComparison(
+	op code.OpCode,
+	left, right object.Object,
+) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLesserThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLesserEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
 	}
 }
 
+func (vm *VM) executeBangOperator() error {
+	operand := vm.pop()
+	// builtins may return booleans other than True and False
+	return vm.push(nativeBoolToBooleanObject(!isTruthy(operand)))
+}
+
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
-	if operand.Type() != object.INTEGER_OBJ {
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.pushNew(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.pushNew(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s",
 			operand.Type())
 	}
-
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
-	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+	switch left.Type() {
+	case object.ARRAY_OBJ:
 		return vm.executeArrayIndex(left, index)
-	case left.Type() == object.HASH_OBJ:
+	case object.STRING_OBJ:
+		return vm.executeStringIndex(left, index)
+	case object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
+	case object.EXCEPTION_OBJ:
+		return vm.executeExceptionIndex(left, index)
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
 }
 
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newKindError(ErrInvalidIndex,
+				"array index must be INTEGER, got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return newKindError(ErrIndexOutOfRange,
+				"index out of range: %d", i.Value)
+		}
+		left.Elements[i.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newKindError(ErrInvalidIndex,
+				"unusable as hash key: %s", index.Type())
+		}
+		if _, ok := left.Get(key); !ok {
+			err := vm.budget.Allocate(1)
+			if err != nil {
+				return err
+			}
+		}
+		left.Set(key, value)
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
 func (vm *VM) executeArrayIndex(left, index object.Object) error {
 	array := left.(*object.Array)
-	i := index.(*object.Integer).Value
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return newKindError(ErrInvalidIndex,
+			"array index must be INTEGER, got %s", index.Type())
+	}
+	i := integer.Value
 	max := int64(len(array.Elements) - 1)
 
 	if i < 0 || i > max {
@@ -442,19 +921,72 @@ func (vm *VM) executeArrayIndex(left, index object.Object) error {
 	return vm.push(array.Elements[i])
 }
 
+func (vm *VM) executeStringIndex(left, index object.Object) error {
+	str := left.(*object.String)
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return newKindError(ErrInvalidIndex,
+			"string index must be INTEGER, got %s", index.Type())
+	}
+
+	char, ok := object.CharAt(str, integer.Value)
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.pushNew(char)
+}
+
 func (vm *VM) executeHashIndex(left, index object.Object) error {
 	hash := left.(*object.Hash)
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+		return newKindError(ErrInvalidIndex,
+			"unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hash.Pairs[key.HashKey()]
+	value, ok := hash.Get(key)
 	if !ok {
 		return vm.push(Null)
 	}
 
-	return vm.push(pair.Value)
+	return vm.push(value)
+}
+
+// executeIterNext pushes the next element of the iterator followed by True,
+// or only False once the iterator is exhausted.
+func (vm *VM) executeIterNext() error {
+	iterator, ok := vm.pop().(*object.Iterator)
+	if !ok {
+		return fmt.Errorf("not an iterator")
+	}
+
+	el, ok := iterator.Next()
+	if !ok {
+		return vm.push(False)
+	}
+
+	err := vm.push(el)
+	if err != nil {
+		return err
+	}
+	return vm.push(True)
+}
+
+func (vm *VM) executeExceptionIndex(left, index object.Object) error {
+	exception := left.(*object.Exception)
+
+	key, ok := index.(*object.String)
+	if !ok {
+		return vm.push(Null)
+	}
+
+	field := exception.Get(key.Value)
+	if field == nil {
+		return vm.push(Null)
+	}
+
+	return vm.push(field)
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -464,6 +996,26 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return False
 }
 
+func isNumber(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Integer, *object.Float:
+		return true
+	default:
+		return false
+	}
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
 	case *object.Boolean:
@@ -486,32 +1038,45 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
 
-		pair := object.HashPair{Key: key, Value: value}
-
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return nil, fmt.Errorf("unusuable as hash key: %s", key.Type())
+			return nil, newKindError(ErrInvalidIndex,
+				"unusable as hash key: %s", key.Type())
 		}
 
-		hashedPairs[hashKey.HashKey()] = pair
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return hash, nil
+}
+
+// pushNew pushes obj, an object the VM just created, and counts it against
+// the allocation limit.
+func (vm *VM) pushNew(obj object.Object) error {
+	err := vm.budget.Allocate(object.Size(obj))
+	if err != nil {
+		return err
+	}
+	return vm.push(obj)
 }
 
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return ErrFrameOverflow
+	}
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -537,22 +1102,106 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 			cl.Fn.NumParameters, numArgs)
 	}
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	if frame.basePointer+cl.Fn.NumLocals >= StackSize {
+		return ErrStackOverflow
+	}
+
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	// clear the remaining locals, a stale cell of an earlier call would
+	// otherwise be written through by OpSetLocal
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+	return nil
+}
+
+// executeTailCall calls a closure in place of the current function, which
+// would only return the result. The closure reuses the frame and the stack
+// window of the function and returns to its caller. Other callees are
+// called as usual, the following OpReturnValue returns their result.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		return vm.executeCall(numArgs)
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	if frame.basePointer+cl.Fn.NumLocals >= StackSize {
+		return ErrStackOverflow
+	}
+
+	// move the closure and its arguments to where the current function
+	// and its arguments are
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = cl
+	frame.ip = -1
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
 	return nil
 }
 
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+
+	// builtins called by callbacks of this one have errors and
+	// reservations of their own
+	outerErr, outerReserved := vm.callbackErr, vm.reserved
+	vm.callbackErr, vm.reserved = nil, 0
+	result := builtin.Fn(vm.builtinContext, args...)
+	callbackErr, reserved := vm.callbackErr, vm.reserved
+	vm.callbackErr, vm.reserved = outerErr, outerReserved
 	vm.sp = vm.sp - numArgs - 1
+
+	// a stopped program stays stopped, even if the builtin ignores it
+	if limit.IsStop(callbackErr) {
+		return callbackErr
+	}
+
+	if err, ok := result.(*object.Error); ok {
+		// pass on the original error of a failed callback, an exception
+		// thrown by it has to arrive unchanged at the handler
+		if callbackErr != nil {
+			return callbackErr
+		}
+		if err.Stop != nil {
+			return err.Stop
+		}
+		return vm.throw(&object.String{Value: err.Message})
+	}
+
+	// the result is counted as a new object, builtins mostly create one,
+	// but without what the builtin reserved for it
 	if result != nil {
-		vm.push(result)
-	} else {
-		vm.push(Null)
+		if size := object.Size(result) - reserved; size > 0 {
+			err := vm.budget.Allocate(size)
+			if err != nil {
+				return err
+			}
+		}
+		return vm.push(result)
 	}
+	return vm.push(Null)
+}
 
-	return nil
+// undefinedError reports reading the variable at index before it got a
+// value, for example because its let statement was skipped.
+func undefinedError(names []string, index int) error {
+	if index < len(names) {
+		return fmt.Errorf("undefined variable %s", names[index])
+	}
+	return fmt.Errorf("undefined variable at index %d", index)
 }
 
 func (vm *VM) pushClosure(constIndex, numFree int) error {
@@ -564,10 +1213,14 @@ func (vm *VM) pushClosure(constIndex, numFree int) error {
 
 	free := make([]object.Object, numFree)
 	for i := 0; i < numFree; i++ {
-		free[i] = vm.stack[vm.sp-numFree+i]
+		captured := vm.stack[vm.sp-numFree+i]
+		if _, ok := captured.(*object.Cell); !ok {
+			captured = &object.Cell{Value: captured}
+		}
+		free[i] = captured
 	}
 	vm.sp -= numFree
 
 	closure := &object.Closure{Fn: function, Free: free}
-	return vm.push(closure)
+	return vm.pushNew(closure)
 }
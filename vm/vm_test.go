@@ -1,13 +1,22 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"monkey/ast"
+	"monkey/code"
 	"monkey/compiler"
 	"monkey/lexer"
+	"monkey/limit"
 	"monkey/object"
 	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 type vmTestCase struct {
@@ -38,6 +47,46 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"3 - 0.5", 2.5},
+		{"2 * 1.25", 2.5},
+		{"1 / 4.0", 0.25},
+		{"7 / 2", 3},
+		{"-2.5", -2.5},
+		{"1e3 * 2", 2000.0},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1 != 1.0", false},
+		{"0.1 + 0.2 == 0.3", false},
+		{"2.5 >= 2.5", true},
+		{"2 <= 1.999", false},
+		{"int(3.9)", 3},
+		{"int(-3.9)", -3},
+		{`int("42")`, 42},
+		{"float(3)", 3.0},
+		{`float("0.5")`, 0.5},
+		{"int(1.0 / 0)",
+			&object.Error{Message: "cannot convert +Inf to INTEGER"},
+		},
+		{`int("abc")`,
+			&object.Error{Message: `cannot convert "abc" to INTEGER`},
+		},
+		{"float(true)",
+			&object.Error{Message: "argument to `float` not supported, got BOOLEAN"},
+		},
+		{"{1: 10, 2.5: 20}[1.0]", 10},
+		{"{1: 10, 2.5: 20}[2.5]", 20},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -69,6 +118,21 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!false", false},
 		{"!!5", true},
 		{"!(if(false) { 5; })", true},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`"a" < "b"`, true},
+		{`"b" <= "a"`, false},
+		{`"abc" > "abd"`, false},
+		{`"b" >= "b"`, true},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] == [1, 2, 3]", false},
+		{"[1, 2] != [1, 3]", true},
+		{`{"a": [1]} == {"a": [1]}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{"[1] == [1.0]", true},
+		{`"1" == 1`, false},
+		{"let a = [1, 0]; a[1] = a; let b = [1, 0]; b[1] = b; a == b", true},
 	}
 
 	runVmTests(t, tests)
@@ -91,6 +155,306 @@ func TestConditionals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestUnreachableLets(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (false) { let x = 1; }; x",
+			&object.Error{Message: "undefined variable x"},
+		},
+		{"if (false) { let x = 1; }; puts(x);",
+			&object.Error{Message: "undefined variable x"},
+		},
+		{"let f = fn() { if (false) { let y = 1; }; y }; f()",
+			&object.Error{Message: "undefined variable y"},
+		},
+		{"while (false) { let v = 1; }; try { v } catch (e) { e.message }", "undefined variable v"},
+		{"while (true) { break; let w = 1; }; try { w } catch (e) { e.message }", "undefined variable w"},
+		{"if (true) { 1 } else { for (i in [1]) {} }; try { i } catch (e) { e.message }", "undefined variable i"},
+		{"if (true) { let x = 1 } else { let x = 2 }; x", 1},
+		{"if (false) { let x = 1 } else { let x = 2 }; x", 2},
+		{"let f = fn() { return 1; let z = 2; }; f()", 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; let sum = fn(n) { let s = 0; let i = 0; while (i < n) { let i = i + 1; let s = s + i; }; s }; sum(10);", 55},
+		{"let f = fn() { let i = 0; while (true) { let i = i + 1; if (i == 5) { break; } }; i }; f();", 5},
+		{"let f = fn() { let i = 0; while (true) { return 7; } }; f();", 7},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; }; let s = s + x; }; s;", 8},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; }; let s = s + x; }; s;", 3},
+		{"let s = 0; for (k in {1: 2, 3: 4}) { let s = s + k; }; s;", 4},
+		{"let f = fn(xs) { let n = 0; for (x in xs) { for (y in xs) { let n = n + x * y; } }; n }; f([1, 2]);", 9},
+		{"let f = fn() { if (true) { let a = 1; } }; f();", Null},
+		{"let i = 0; while (i < 5000) { let i = i + 1; }; i;", 5000},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x;", 2},
+		{"let x = 1; x = x + 1;", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4;", 6},
+		{"let x = 1; let y = 2; x = y = 3; x + y;", 6},
+		{"let f = fn() { let x = 1; x += 1; x }; f();", 2},
+		{"let f = fn(n) { n *= 2; n }; f(21);", 42},
+		{"let a = [1, 2, 3]; a[1] = 5; a;", []int{1, 5, 3}},
+		{"let a = [1, 2, 3]; a[2] += 10; a;", []int{1, 2, 13}},
+		{"let a = [1]; let b = a; b[0] = 2; a[0];", 2},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 3; h["a"] + h["b"];`, 5},
+		{"let i = 0; let s = 0; while (i < 5) { i += 1; s += i; }; s;", 15},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignmentsToCapturedVariables(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let counter = fn() {
+				let count = 0;
+				fn() { count += 1 }
+			};
+			let c = counter();
+			c(); c();
+			c();
+			`,
+			expected: 3,
+		},
+		{
+			input: `
+			let pair = fn() {
+				let value = 0;
+				let get = fn() { value };
+				let set = fn(v) { value = v };
+				[get, set]
+			};
+			let p = pair();
+			p[1](42);
+			p[0]();
+			`,
+			expected: 42,
+		},
+		{
+			input: `
+			let f = fn() {
+				let x = 1;
+				let g = fn() { fn() { x = x * 10 } };
+				g()();
+				x
+			};
+			f();
+			`,
+			expected: 10,
+		},
+		{
+			input: `
+			let make = fn() {
+				let x = 0;
+				fn() { x += 1 }
+			};
+			let a = make();
+			let b = make();
+			a(); a();
+			b();
+			`,
+			expected: 1,
+		},
+		{
+			input: `
+			let f = fn(n) {
+				let x = n;
+				let g = fn() { x };
+				x = x + 1;
+				g()
+			};
+			f(1) + f(10);
+			`,
+			expected: 13,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []vmTestCase{
+		{`let r = ""; try { throw "boom"; } catch (e) { r = e["message"]; }; r;`, "boom"},
+		{`let r = ""; try { len(1) } catch (e) { r = e["message"] }; r;`,
+			"argument to `len` not supported, got INTEGER"},
+		{`let r = ""; try { 1 / 0 } catch (e) { r = e["message"] }; r;`, "division by zero"},
+		{`let r = ""; try { 1 + true } catch (e) { r = e["message"] }; r;`,
+			"unsupported types for binary operation: INTEGER BOOLEAN"},
+		{`
+		let f = fn() { throw "inner" };
+		let g = fn(x) { let y = x; f() };
+		let r = "";
+		try { g(1) } catch (e) { r = e["message"] };
+		r;
+		`, "inner"},
+		{`let r = 1 + fn() { try { throw "x" } catch (e) { return 2 } }(); r;`, 3},
+		{`let n = 0; try { n += 1 } finally { n += 10 }; n;`, 11},
+		{`let n = 0; try { throw "x" } catch (e) { n += 1 } finally { n += 10 }; n;`, 11},
+		{`let n = 0; let f = fn() { try { return 1 } finally { n += 10 } }; f() + n;`, 11},
+		{`let n = 0; while (true) { try { break; } finally { n += 1 } }; n;`, 1},
+		{`
+		let n = 0;
+		for (x in [1, 2, 3]) {
+			try {
+				if (x == 2) { continue; }
+				n += x;
+			} finally {
+				n += 10;
+			}
+		}
+		n;
+		`, 34},
+		{`
+		let r = "";
+		try {
+			try { throw "a" } catch (e) { throw e["message"] + "b" }
+		} catch (e) {
+			r = e["message"]
+		};
+		r;
+		`, "ab"},
+		{`
+		let n = 0;
+		let r = "";
+		try {
+			try { throw "a" } catch (e) { throw "b" } finally { n += 1 }
+		} catch (e) {
+			r = e["message"]
+		};
+		if (n == 1) { r } else { "finally skipped" };
+		`, "b"},
+		{`
+		let n = 0;
+		let r = "";
+		try {
+			try { throw "a" } finally { n += 1 }
+		} catch (e) {
+			r = e["message"]
+		};
+		if (n == 1) { r } else { "finally skipped" };
+		`, "a"},
+		{`
+		let f = fn() { throw "x" };
+		let t = [];
+		try { f() } catch (e) { t = e["trace"] };
+		len(t);
+		`, 2},
+		{`let f = fn() { 1 + f() }; let r = ""; try { f() } catch (e) { r = e["message"] }; r;`,
+			"maximum call depth exceeded"},
+		{`let e = 0; try { throw 42 } catch (e) { e };`, 42},
+		{`try { throw {"code": 1} } catch (e) { e["code"] };`, 1},
+		{`try { try { throw [7] } catch (e) { throw e } } catch (e) { e[0] };`, 7},
+		{`throw 42;`, &object.Error{Message: "42"}},
+		{`throw "uncaught";`, &object.Error{Message: "uncaught"}},
+		{`try { throw "a" } finally { 1 };`, &object.Error{Message: "a"}},
+		{`try { throw "a" } catch (e) { throw e };`, &object.Error{Message: "a"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		kind     error
+	}{
+		{"1 / 0", "division by zero", ErrDivisionByZero},
+		{"let f = fn(x) { 10 / x }; f(0);", "division by zero", ErrDivisionByZero},
+		{`[1, 2]["a"]`, "array index must be INTEGER, got STRING", ErrInvalidIndex},
+		{"[1, 2][1.0]", "array index must be INTEGER, got FLOAT", ErrInvalidIndex},
+		{"{1: 2}[fn() {}]", "unusable as hash key: CLOSURE_OBJ", ErrInvalidIndex},
+		{"{[1]: 2}", "unusable as hash key: ARRAY", ErrInvalidIndex},
+		{"let a = [1]; a[1] = 2;", "index out of range: 1", ErrIndexOutOfRange},
+		{`let a = [1]; a["x"] = 2;`, "array index must be INTEGER, got STRING", ErrInvalidIndex},
+		{`let s = "abc"; s[0] = "x";`, "index assignment not supported: STRING", nil},
+		{"let f = fn() { 1 + f() }; f();", "maximum call depth exceeded", ErrFrameOverflow},
+		{"let f = fn(a, b) { 1 + f(a, b) }; f(1, 2);", "stack overflow", ErrStackOverflow},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.ByteCode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+
+		if tt.kind != nil && !errors.Is(err, tt.kind) {
+			t.Errorf("error %q is not of kind %q", err, tt.kind)
+		}
+	}
+}
+
+func TestStackUnderflow(t *testing.T) {
+	bytecode := &compiler.ByteCode{
+		Instructions: code.Make(code.OpPop),
+	}
+
+	vm := New(bytecode)
+	err := vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	if !errors.Is(err, ErrStackUnderflow) {
+		t.Fatalf("wrong VM error: want=%q, got=%q", ErrStackUnderflow, err)
+	}
+
+	if _, ok := err.(*RuntimeError); !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+}
+
+func TestUnknownOpcode(t *testing.T) {
+	bytecode := &compiler.ByteCode{
+		Instructions: code.Instructions{255},
+	}
+
+	err := New(bytecode).Run()
+	if err == nil || err.Error() != "unknown opcode 255" {
+		t.Fatalf("wrong VM error: want=%q, got=%v", "unknown opcode 255", err)
+	}
+}
+
+func TestIteratingNonIterable(t *testing.T) {
+	program := parse("for (x in 1) { x }")
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.ByteCode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	if err.Error() != "cannot iterate over INTEGER" {
+		t.Fatalf("wrong VM error: want=%q, got=%q", "cannot iterate over INTEGER", err)
+	}
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []vmTestCase{
 		{"let one = 1; one", 1},
@@ -149,6 +513,14 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		{`"abc"[1]`, "b"},
+		{`"abc"[3]`, Null},
+		{`"abc"[-1]`, Null},
+		{`"äöü"[1]`, "ö"},
+		{`let r = ""; for (k in {"b": 1, "a": 2, "c": 3}) { r += k }; r`, "bac"},
+		{`"abc"["a"]`,
+			&object.Error{Message: "string index must be INTEGER, got STRING"},
+		},
 	}
 
 	runVmTests(t, tests)
@@ -197,6 +569,10 @@ func TestFunctionsWithoutReturnStatement(t *testing.T) {
 			input:    "let a=fn(){};let b=fn(){a();};a();b();",
 			expected: Null,
 		},
+		{"let f = fn() { let y = 1; }; f();", Null},
+		{"[fn() {}()][0];", Null},
+		{`{"a": fn() {}()}["a"];`, Null},
+		{"let x = 0; x = fn() {}(); x;", Null},
 	}
 	runVmTests(t, tests)
 }
@@ -424,6 +800,135 @@ func TestBuiltinFunctions(t *testing.T) {
 				Message: "argument to `push` must be ARRAY, got INTEGER",
 			},
 		},
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map([], fn(x) { x })`, []int{}},
+		{`map([1], len)`,
+			&object.Error{
+				Message: "argument to `len` not supported, got INTEGER",
+			},
+		},
+		{`map(1, fn(x) { x })`,
+			&object.Error{
+				Message: "argument to `map` must be ARRAY, got INTEGER",
+			},
+		},
+		{`map([1], 1)`,
+			&object.Error{
+				Message: "argument to `map` must be a function, got INTEGER",
+			},
+		},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`reduce([1, 2, 3, 4], 10, fn(acc, x) { acc + x })`, 20},
+		{`reduce([], 10, fn(acc, x) { acc + x })`, 10},
+		{`let sum = 0; each([1, 2, 3], fn(x) { sum += x }); sum`, 6},
+		{`each([1], fn(x) { x })`, Null},
+		{`sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+		{`sort([3, 1, 2], fn(a, b) { a - b })`, []int{1, 2, 3}},
+		{`let a = [2, 1]; sort(a); a`, []int{2, 1}},
+		{`sort([1, "a"])`,
+			&object.Error{
+				Message: "cannot compare STRING and INTEGER, pass a comparator to `sort`",
+			},
+		},
+		{`sort([1, 2], fn(a, b) { "x" })`,
+			&object.Error{
+				Message: "comparator must return BOOLEAN or INTEGER, got STRING",
+			},
+		},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, 2},
+		{`find([1, 2, 3], fn(x) { x > 3 })`, Null},
+		{`any([1, 2, 3], fn(x) { x > 2 })`, true},
+		{`any([], fn(x) { true })`, false},
+		{`all([1, 2, 3], fn(x) { x > 0 })`, true},
+		{`all([1, 2, 3], fn(x) { x > 1 })`, false},
+		{`!any([1], fn(x) { false })`, true},
+		{`!all([1], fn(x) { true })`, false},
+		{`let f = fn(x) { map(x, fn(y) { y + len(x) }) }; f([1, 2])`, []int{3, 4}},
+		{`map([[1, 2], [3]], fn(x) { reduce(x, 0, fn(a, b) { a + b }) })`, []int{3, 3}},
+		{`try { map([1, 2], fn(x) { throw "bad" }) } catch (e) { e["message"] }`, "bad"},
+		{`map([1, 2], fn(x) { try { throw x } catch (e) { return 0 } })`, []int{0, 0}},
+		{`let f = fn() { map([1], fn(x) { return 5; 6 }) }; f()`, []int{5}},
+		{`let cmp = fn(a, b) { try { map([1], fn(x) { throw "stale" }) } catch (e) {}; "notint" };
+		try { sort([2, 1], cmp) } catch (e) { e.message }`,
+			"comparator must return BOOLEAN or INTEGER, got STRING",
+		},
+		{`map([1], fn(x) { x / 0 })`,
+			&object.Error{Message: "division by zero"},
+		},
+		{`len("äöü")`, 3},
+		{`join(split("a,b,,c", ","), "|")`, "a|b||c"},
+		{`len(split("abc", ""))`, 3},
+		{`join([], ",")`, ""},
+		{`join(["a", 1], ",")`,
+			&object.Error{
+				Message: "elements joined by `join` must be STRING, got INTEGER",
+			},
+		},
+		{`trim("  a b  ")`, "a b"},
+		{`upper("abc")`, "ABC"},
+		{`lower("ÄBC")`, "äbc"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`contains("monkey", "key")`, true},
+		{`contains("monkey", "donkey")`, false},
+		{`starts_with("monkey", "mon")`, true},
+		{`ends_with("monkey", "mon")`, false},
+		{`!contains("abc", "z")`, true},
+		{`!starts_with("abc", "a")`, false},
+		{`!ends_with("abc", "b")`, true},
+		{`index_of("monkey", "key")`, 3},
+		{`index_of("äöü", "ü")`, 2},
+		{`index_of("monkey", "x")`, -1},
+		{`substr("monkey", 3)`, "key"},
+		{`substr("monkey", 1, 3)`, "onk"},
+		{`substr("äöü", 1, 100)`, "öü"},
+		{`substr("monkey", 7)`,
+			&object.Error{Message: "start of `substr` out of range: 7"},
+		},
+		{`substr("monkey", 0, -1)`,
+			&object.Error{Message: "length of `substr` must not be negative, got -1"},
+		},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", -1)`,
+			&object.Error{Message: "count of `repeat` must not be negative, got -1"},
+		},
+		{`repeat("ab", 4611686018427387903)`,
+			&object.Error{Message: "result of `repeat` too large: 4611686018427387903 times 2 bytes"},
+		},
+		{`replace(repeat("a", 100000), "a", repeat("b", 100000))`,
+			&object.Error{Message: "result of `replace` too large: 10000000000 bytes"},
+		},
+		{`repeat("", 4611686018427387903)`, ""},
+		{`join(chars("äb"), "-")`, "ä-b"},
+		{`upper(1)`,
+			&object.Error{Message: "argument to `upper` must be STRING, got INTEGER"},
+		},
+		{`replace("a", "b")`,
+			&object.Error{Message: "wrong number of arguments. got=2, want=3"},
+		},
+		{`join(keys({"b": 1, "a": 2, "c": 3}), ",")`, "b,a,c"},
+		{`values({"b": 1, "a": 2, "c": 3})`, []int{1, 2, 3}},
+		{`entries({"b": 1, "a": 2})[1][0]`, "a"},
+		{`entries({"b": 1, "a": 2})[1][1]`, 2},
+		{`entries({})`, []int{}},
+		{`has({"a": 1}, "a")`, true},
+		{`has({"a": 1}, "b")`, false},
+		{`!has({}, 1)`, true},
+		{`has({"a": 1}, [])`,
+			&object.Error{Message: "unusable as hash key: ARRAY"},
+		},
+		{`let h = {"a": 1, "b": 2}; let d = delete(h, "a"); [len(keys(h)), len(keys(d))]`, []int{2, 1}},
+		{`let h = {"a": 1, "b": 2}; let v = remove(h, "a"); [v, len(keys(h))]`, []int{1, 1}},
+		{`remove({"a": 1}, "b")`, Null},
+		{`let h = {"a": 1, "b": 2}; remove(h, "a"); h["a"] = 3; join(keys(h), ",")`, "b,a"},
+		{`join(keys(merge({"a": 1, "b": 2}, {"c": 3, "a": 4})), ",")`, "a,b,c"},
+		{`merge({"a": 1, "b": 2}, {"c": 3, "a": 4})["a"]`, 4},
+		{`merge({"a": 1}, [])`,
+			&object.Error{Message: "argument to `merge` must be HASH, got ARRAY"},
+		},
+		{`keys([])`,
+			&object.Error{Message: "argument to `keys` must be HASH, got ARRAY"},
+		},
 	}
 
 	runVmTests(t, tests)
@@ -560,6 +1065,34 @@ func TestRecursiveFunctions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		// far deeper than MaxFrames
+		{`let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } };
+		sum(100000, 0)`, 5000050000},
+		{`let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); };
+		sum(100000, 0)`, 5000050000},
+		{`let odd = 0;
+		let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+		odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+		even(10001)`, false},
+		// captured variables keep their values when the frame is reused
+		{`let collect = fn(n, fns) {
+			let get = fn() { n };
+			if (n == 0) { map(fns, fn(f) { f() }) } else { collect(n - 1, push(fns, get)) }
+		};
+		collect(3, [])`, []int{3, 2, 1}},
+		{`let count = fn(a) { len(a) }; count([1, 2])`, 2},
+		{`let f = fn(x) { x * 2 }; map([1, 2], fn(x) { f(x) })`, []int{2, 4}},
+		// calls inside try are not tail calls, the handler stays in place
+		{`let fail = fn() { throw "boom" };
+		let f = fn() { try { return fail(); } catch (e) { return e["message"]; } };
+		f()`, "boom"},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestRecursiveFibonacci(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -587,23 +1120,39 @@ func TestRecursiveFibonacci(t *testing.T) {
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
-	for _, tt := range tests {
-		program := parse(tt.input)
-		compiler := compiler.New()
-		err := compiler.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
-		}
+	// optimizations must not change the results of programs
+	for _, level := range []int{compiler.OptimizeNone, compiler.OptimizeBasic} {
+		for _, tt := range tests {
+			program := parse(tt.input)
+			comp := compiler.New()
+			comp.SetOptimizationLevel(level)
+			err := comp.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
 
-		vm := New(compiler.ByteCode())
-		err = vm.Run()
-		if err != nil {
-			t.Fatalf("vm error: %s", err)
-		}
+			vm := New(comp.ByteCode())
+			err = vm.Run()
+
+			// errors of builtins are thrown and end the program if uncaught
+			if expected, ok := tt.expected.(*object.Error); ok {
+				if err == nil {
+					t.Fatalf("expected VM error %q but resulted in none.", expected.Message)
+				}
+				if err.Error() != expected.Message {
+					t.Errorf("wrong VM error: want=%q, got=%q", expected.Message, err)
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
 
-		stackElem := vm.LastPoppedStackElement()
+			stackElem := vm.LastPoppedStackElement()
 
-		testExpectedObject(t, tt.expected, stackElem)
+			testExpectedObject(t, tt.expected, stackElem)
+		}
 	}
 }
 
@@ -625,6 +1174,11 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testBooleanObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case string:
 		err := testStringObject(expected, actual)
 		if err != nil {
@@ -657,14 +1211,19 @@ func testExpectedObject(
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
+		if hash.Len() != len(expected) {
 			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
-				len(expected), len(hash.Pairs))
+				len(expected), hash.Len())
 			return
 		}
 
+		pairs := map[object.HashKey]object.HashPair{}
+		for _, pair := range hash.Pairs() {
+			pairs[pair.Key.(object.Hashable).HashKey()] = pair
+		}
+
 		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+			pair, ok := pairs[expectedKey]
 			if !ok {
 				t.Errorf("no pair for given key in Pairs")
 			}
@@ -713,6 +1272,21 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value: got=%g, want=%g",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -742,3 +1316,309 @@ func testStringObject(expected string, actual object.Object) error {
 
 	return nil
 }
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(x) {
+	x + true
+};
+let outer = fn() {
+	inner(1) + 1;
+};
+outer();`
+
+	lexer := lexer.NewWithFilename(input, "trace.monkey")
+	program := parser.New(lexer).ParseProgram()
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.ByteCode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	expectedMessage := "unsupported types for binary operation: INTEGER BOOLEAN"
+	if runtimeErr.Message != expectedMessage {
+		t.Errorf("wrong message. want=%q, got=%q", expectedMessage, runtimeErr.Message)
+	}
+
+	expectedTrace := []TraceEntry{
+		{Function: "inner", Filename: "trace.monkey", Line: 2},
+		{Function: "outer", Filename: "trace.monkey", Line: 5},
+		{Function: "<main>", Filename: "trace.monkey", Line: 7},
+	}
+
+	if len(runtimeErr.Trace) != len(expectedTrace) {
+		t.Fatalf("wrong trace length. want=%+v, got=%+v",
+			expectedTrace, runtimeErr.Trace)
+	}
+
+	for i, entry := range expectedTrace {
+		if runtimeErr.Trace[i] != entry {
+			t.Errorf("wrong trace entry %d. want=%+v, got=%+v",
+				i, entry, runtimeErr.Trace[i])
+		}
+	}
+
+	expectedStackTrace := "\tat inner (trace.monkey:2)\n" +
+		"\tat outer (trace.monkey:5)\n" +
+		"\tat <main> (trace.monkey:7)\n"
+	if runtimeErr.StackTrace() != expectedStackTrace {
+		t.Errorf("wrong stack trace. want=%q, got=%q",
+			expectedStackTrace, runtimeErr.StackTrace())
+	}
+}
+
+// writeModules creates the modules used by the import tests in a temporary
+// directory and returns the directory.
+func writeModules(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	modules := map[string]string{
+		"lib/math.monkey": `let square = fn(x) { x * x };
+export let pi = 3;
+export let area = fn(r) { pi * square(r) };`,
+		"counter.monkey": `export let state = {"n": 0};`,
+		"bump.monkey": `import "counter.monkey" as c;
+export let bump = fn() { c.state["n"] = c.state["n"] + 1; };`,
+		"private.monkey": `let secret = 1; export let shown = 2;`,
+		"outer.monkey":   `export let x = y;`,
+		"cycle_a.monkey": `import "cycle_b.monkey" as b;`,
+		"cycle_b.monkey": `import "cycle_a.monkey" as a;`,
+		"large.monkey":   manyLets(300) + "export let early = v43;",
+		"returns.monkey": "export let a = 1;\nreturn 5;\nexport let b = 2;",
+	}
+	for name, contents := range modules {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// manyLets returns n let statements binding v0 to vn-1.
+func manyLets(n int) string {
+	var out strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&out, "let v%d = %d;\n", i, i)
+	}
+	return out.String()
+}
+
+func TestModules(t *testing.T) {
+	dir := writeModules(t)
+
+	tests := []vmTestCase{
+		{`import "lib/math.monkey" as m; m.area(2)`, 12},
+		{`import "lib/math.monkey" as m; m["pi"]`, 3},
+		{`import "math.monkey" as m; m.pi`, 3},
+		{`import "private.monkey" as p; p.shown`, 2},
+		{`import "private.monkey" as p; p.secret`, Null},
+		{`import "counter.monkey" as c; import "bump.monkey" as b;
+		b.bump(); b.bump(); c.state["n"]`, 2},
+		{`let f = fn() { import "lib/math.monkey" as m; m.pi }; f() + f()`, 6},
+		{`let pi = 1; import "lib/math.monkey" as m; m.pi + pi`, 4},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(
+			lexer.NewWithFilename(tt.input, filepath.Join(dir, "main.monkey"))).ParseProgram()
+		comp := compiler.New()
+		comp.SetSearchPath([]string{filepath.Join(dir, "lib")})
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("%q: compiler error: %s", tt.input, err)
+		}
+
+		vm := New(comp.ByteCode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("%q: vm error: %s", tt.input, err)
+		}
+
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElement())
+	}
+}
+
+func TestManyLocals(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn() {\n" + manyLets(256) + "v43 + v255 }; f()", 298},
+		{"let f = fn() {\n" + manyLets(255) + "fn() { v43 + v254 } }; f()()", 297},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestModuleErrors(t *testing.T) {
+	dir := writeModules(t)
+	cycleA, cycleB := filepath.Join(dir, "cycle_a.monkey"), filepath.Join(dir, "cycle_b.monkey")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "missing.monkey" as m;`, `cannot find module "missing.monkey"`},
+		{`let y = 1; import "outer.monkey" as o;`, "undefined variable y"},
+		{`import "cycle_a.monkey" as a;`,
+			"import cycle: " + cycleA + " -> " + cycleB + " -> " + cycleA},
+		{`import "large.monkey" as l; l.early`,
+			"module has too many local variables: 301, at most 256 are allowed"},
+		{"let f = fn() {\n" + manyLets(257) + "};",
+			"main.monkey:1:9: function has too many local variables: 257, at most 256 are allowed"},
+		{`import "returns.monkey" as r; puts(r);`,
+			"returns.monkey:2:1: return outside of function in module"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(
+			lexer.NewWithFilename(tt.input, filepath.Join(dir, "main.monkey"))).ParseProgram()
+		err := compiler.New().Compile(program)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-expired.Done()
+
+	tests := []struct {
+		input    string
+		limits   limit.Limits
+		ctx      context.Context
+		expected error
+	}{
+		{"while (true) {}", limit.Limits{MaxInstructions: 10000}, nil, limit.ErrInstructions},
+		{"let a = []; while (true) { a = push(a, 1) }",
+			limit.Limits{MaxAllocations: 10000}, nil, limit.ErrAllocations},
+		{`let s = ""; while (true) { s = s + "monkey" }`,
+			limit.Limits{MaxAllocations: 10000}, nil, limit.ErrAllocations},
+		{`repeat("abcdefgh", 100000000)`,
+			limit.Limits{MaxAllocations: 100000}, nil, limit.ErrAllocations},
+		{`replace(repeat("a", 10000), "a", repeat("b", 10000))`,
+			limit.Limits{MaxAllocations: 100000}, nil, limit.ErrAllocations},
+		{`let s = repeat("a", 8000); try { join([s, s], "") } catch (e) {}; 1`,
+			limit.Limits{MaxAllocations: 1500}, nil, limit.ErrAllocations},
+		{`split(repeat(",", 50000), ",")`,
+			limit.Limits{MaxAllocations: 100000}, nil, limit.ErrAllocations},
+		{`let s = repeat("a", 8000); len(s)`,
+			limit.Limits{MaxAllocations: 1100}, nil, nil},
+		{"while (true) {}", limit.Limits{}, expired, context.DeadlineExceeded},
+		{"try { while (true) {} } catch (e) {}; 1",
+			limit.Limits{MaxInstructions: 10000}, nil, limit.ErrInstructions},
+		{"let n = 0; try { while (true) {} } finally { n = 1 }; n",
+			limit.Limits{MaxInstructions: 10000}, nil, limit.ErrInstructions},
+		{`let f = fn(x) { while (true) {} };
+		try { map([1], f) } catch (e) {}; 1`,
+			limit.Limits{MaxInstructions: 10000}, nil, limit.ErrInstructions},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } }; f(100)",
+			limit.Limits{MaxInstructions: 100000, MaxAllocations: 10000}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		ctx := tt.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		vm := New(comp.ByteCode())
+		vm.SetLimits(tt.limits)
+		err = vm.RunContext(ctx)
+
+		if tt.expected == nil {
+			if err != nil {
+				t.Errorf("%q: vm error: %s", tt.input, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.expected) || !limit.IsStop(err) {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestCallLimits(t *testing.T) {
+	program := parse("fn() { while (true) {} }")
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.ByteCode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	fn := vm.LastPoppedStackElement()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = vm.CallContext(ctx, fn)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wrong error. want=%q, got=%v", context.DeadlineExceeded, err)
+	}
+
+	vm.SetLimits(limit.Limits{MaxInstructions: 10000})
+	_, err = vm.Call(fn)
+	if !errors.Is(err, limit.ErrInstructions) {
+		t.Errorf("wrong error. want=%q, got=%v", limit.ErrInstructions, err)
+	}
+}
+
+func TestBuiltinIO(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`puts("hello", 1, [2, 3])`, "hello\n1\n[2, 3]\n"},
+		{`let f = fn(x) { puts(x); x }; map([1, 2], f)`, "1\n2\n"},
+		{`puts()`, ""},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		vm := New(comp.ByteCode())
+		vm.SetIO(object.IO{Stdout: &stdout, Stderr: &stderr, Stdin: strings.NewReader("")})
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		if stdout.String() != tt.expected {
+			t.Errorf("%q: wrong output. want=%q, got=%q", tt.input, tt.expected, stdout.String())
+		}
+		if stderr.Len() != 0 {
+			t.Errorf("%q: unexpected output on stderr: %q", tt.input, stderr.String())
+		}
+	}
+}